@@ -78,28 +78,37 @@ func main() {
 	stepSequence[0xe] = Step{Note: 0}
 	stepSequence[0xf] = Step{Note: 3, Accent: true}
 
-	// create an unlimited duration playback event
-	// so we can simulate a mono-synth with proper note slides
-	event, _ := e.Prepare(waveformSlot, 0, 0)
+	// create a mono voice (which plays 1 note at a time, gliding between
+	// overlapping notes like a tb303)
+	voice, err := e.PrepareMonoVoice(waveformSlot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer voice.Close()
 	// we use a single cycle waveform, so turn on looping
-	event.SetLooping(true)
-	event.SetLoopSlice(0.0, 0.001)
-	// initially set the gain to negative infinity
-	event.SetGain(stereophonic.GainNegativeInfinity)
+	voice.SetLooping(true)
+	voice.SetLoopSlice(0.0, 0.001)
+	// glide for the length of a step
+	voice.SetGlide(sixteenthNoteDurationInSeconds)
+	// set the gain (and how much louder accents are)
+	voice.SetGain(normalGain)
+	voice.SetAccent(accentGain, 0.0)
 	// set initial filter values
-	event.SetFilterCutoff(filterCutoff)
-	event.SetFilterResonance(filterResonance)
-	event.SetFilterEnvelopeOn(true)
-	event.SetFilterEnvelopeDepth(filterEnvelopeDepth)
-	event.SetFilterAttack(filterAttackInSeconds)
-	event.SetFilterDecay(filterDecayInSeconds)
-	event.SetFilterSustain(0)
-	event.SetFilterResonance(filterResonance)
-	// commence play
-	e.Play(event)
+	voice.SetFilterCutoff(filterCutoff)
+	voice.SetFilterResonance(filterResonance)
+	voice.SetFilterEnvelopeOn(true)
+	voice.SetFilterEnvelopeDepth(filterEnvelopeDepth)
+	voice.SetFilterAttack(filterAttackInSeconds)
+	voice.SetFilterDecay(filterDecayInSeconds)
+	voice.SetFilterSustain(0)
 
 	// for each step in our step sequence
 	go func() {
+		// the note held over from a slide step (if there is one)
+		var (
+			heldNote    int
+			hasHeldNote bool
+		)
 		for {
 			// get the current step
 			step := stepSequence[currentStepIndex]
@@ -109,43 +118,44 @@ func main() {
 			// these current step values
 			if step.Off {
 				// === off-step ===
-				event.SetGain(stereophonic.GainNegativeInfinity)
+				voice.AllNotesOff()
+				hasHeldNote = false
+				waitStep()
+				continue
+			}
+			// === on-step ===
+			note := step.Note + noteOffset
+			// pressing this note while the previous (slide) note is
+			// still held glides to it (without retriggering)
+			voice.NoteOn(note, step.Accent)
+			if hasHeldNote && heldNote != note {
+				voice.NoteOff(heldNote)
+			}
+			heldNote, hasHeldNote = note, true
+			// if the step's slide is on, the note is held into the
+			// next step, otherwise it's released halfway through
+			if step.Slide {
+				waitStep()
 			} else {
-				// === on-step ===
-				// set note value
-				event.SetNote(step.Note + noteOffset)
-				// if the step's slide is on
-				if step.Slide {
-					// slide to next step (assuming that it
-					// is also an on-step)
-					if !stepSequence[currentStepIndex].Off {
-						event.SetNote(
-							stepSequence[currentStepIndex].Note+noteOffset,
-							sixteenthNoteDurationInSeconds)
-					}
-				} else {
-					// else the slide wasn't on and we
-					// reset the envelopes to attack stage
-					event.Attack()
-				}
-				// set gain for this step
-				event.SetGain(normalGain)
-				if step.Accent {
-					event.SetGain(accentGain)
-				}
+				waitHalfStep()
+				voice.NoteOff(note)
+				hasHeldNote = false
+				waitHalfStep()
 			}
-			waitStep()
 		}
 	}()
 
 	// allow events to occur
 	time.Sleep(time.Duration(32 * quarterNoteDurationInSeconds * float64(time.Second)))
-	// turn off the (indefinite and looping) event
-	event.SetLooping(false)
-	event.Release()
+	// turn off the voice
+	voice.AllNotesOff()
 
 }
 
 func waitStep() {
 	time.Sleep(time.Duration(sixteenthNoteDurationInSeconds * float64(time.Second)))
 }
+
+func waitHalfStep() {
+	time.Sleep(time.Duration(sixteenthNoteDurationInSeconds / 2.0 * float64(time.Second)))
+}
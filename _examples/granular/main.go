@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stygian-phrygian/stereophonic"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+
+	// create an engine
+	e, err := stereophonic.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.Close()
+
+	// start engine
+	if err := e.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	// load a sound file into a slot
+	s1 := 1
+	sampleDirectory := os.Getenv("GOPATH") + "src/github.com/stygian-phrygian/stereophonic/_examples/samples/"
+	if err := e.Load(s1, sampleDirectory+"707ride.wav"); err != nil {
+		log.Fatal(err)
+	}
+
+	// prepare a granular playback event
+	startTimeInSeconds := 0.0
+	durationInSeconds := 10.0
+	event, err := e.PrepareGranular(s1, startTimeInSeconds, durationInSeconds)
+	if err != nil {
+		log.Fatal(err)
+	}
+	event.SetGrainSize(0.08)
+	event.SetGrainDensity(40)
+	event.SetGrainPositionJitter(0.02)
+	event.SetGrainPitchSpread(0.2)
+	event.SetGrainPanSpread(1.0)
+	event.SetNote(-12)
+	event.SetAmplitudeRelease(2.0)
+
+	// start playback
+	e.Play(event)
+
+	// spawn a thread which scans the grain position through the sample
+	go func() {
+		position := 0.0
+		for {
+			time.Sleep(50 * time.Millisecond)
+			event.SetGrainPosition(position)
+			position += 0.002
+		}
+	}()
+
+	fmt.Printf("\n\nGranular texture for %0.2fs\n", durationInSeconds)
+	time.Sleep(time.Duration(((durationInSeconds + 2.0) * float64(time.Second))))
+}
@@ -13,6 +13,25 @@ import (
 // a doneAction callback can also be specified, and which runs
 // when the adsr envelope finishes the release stage (when release()
 // is called)
+//
+// It's actually a dahdsr envelope, there's an optional delay stage (before
+// the attack) and hold stage (at the peak, after the attack), both of which
+// default to 0 seconds.
+//
+// Each segment (attack, decay, release) has a curve:
+// exponential: (the default) the level is multiplied by a constant each tick
+// linear:      the level changes by a constant each tick
+// logarithmic: the mirror image of exponential (fast where it's slow)
+// tension:     adjustable from -1 (starts quickly, then slows) through 0
+//              (linear) to 1 (starts slowly, then quickens)
+//
+// Every segment starts from the level the envelope is actually at (so
+// releasing during the attack doesn't jump to the sustain level).  How
+// attack() retriggers a sounding envelope depends on its retrigger mode:
+// reset:         fade quickly (de-click) to silence, then attack from there
+// current level: (the default) attack from the current level
+// legato:        don't retrigger (unless it's releasing/off, in which case
+//                attack from the current level)
 
 // much of the algorithm below is inspired from the article found here:
 // http://www.martin-finke.de/blog/articles/audio-plugins-011-envelopes/
@@ -24,10 +43,36 @@ const (
 	adsrDecayStage
 	adsrSustainStage
 	adsrReleaseStage
+	adsrDelayStage
+	adsrHoldStage
+	adsrDeclickStage
 	//
 	adsrNumberOfStages
 	//
 	adsrMinimumLevel float64 = 0.0001
+	// how much a tension of 1 bends a tension curve
+	adsrMaximumTension float64 = 10.0
+	// how long the de-click fade (before a reset retrigger) lasts
+	adsrDeclickInSeconds float64 = 0.002
+)
+
+// envelope retrigger modes
+type RetriggerMode int
+
+const (
+	RetriggerReset RetriggerMode = iota
+	RetriggerFromCurrentLevel
+	RetriggerLegato
+)
+
+// envelope segment curves
+type EnvelopeCurve int
+
+const (
+	ExponentialCurve EnvelopeCurve = iota
+	LinearCurve
+	LogarithmicCurve
+	TensionCurve
 )
 
 type adsrEnvelope struct {
@@ -35,41 +80,55 @@ type adsrEnvelope struct {
 	// and off stage values, which represent levels).  This is a float64
 	// slice because of the sustain level (which must be a float64)
 	stage []float64
+	// the curve (and tension of tension curves) of each stage
+	curve   []EnvelopeCurve
+	tension []float64
 	// which stage we're in (used for indexing into the stage[] above)
 	currentStage int
 	// which tick we are at (how far from stage completion that is)
 	currentTick int
 	// the level of the envelope (obviously)
 	currentLevel float64
-	// the multiplier to increment/decrement the current level each tick
-	multiplier float64
+	// the current segment is computed (each tick) as:
+	//   curveValue = curveValue * multiplier + increment
+	//   currentLevel = offset + scale * curveValue
+	// which (depending on these values) is any of the curves
+	curveValue, multiplier, increment, offset, scale float64
 	//
 	sampleRate float64
+	// how attack() retriggers the envelope
+	retriggerMode RetriggerMode
 	// the done action callback (called after the release stage finishes)
 	doneAction func()
 }
 
 // setters
+func (adsr *adsrEnvelope) setDelay(delayTimeInSeconds float64) {
+	adsr.stage[adsrDelayStage] = math.Floor(math.Max(delayTimeInSeconds*adsr.sampleRate, 0.0))
+}
 func (adsr *adsrEnvelope) setAttack(attackTimeInSeconds float64) {
 	attackTimeInFrames := math.Floor(math.Max(attackTimeInSeconds*adsr.sampleRate, 0.0))
 	adsr.stage[adsrAttackStage] = attackTimeInFrames
-	// [edge case] if we're in the same stage currently, fix the multiplier
+	// [edge case] if we're in the same stage currently, fix the segment
 	if adsr.currentStage == adsrAttackStage {
 		// calculate the discrepancy of ticks left to compute
 		ticksLeft := attackTimeInFrames - float64(adsr.currentTick)
-		// update multipler
-		adsr.multiplier = calculateLevelMultiplier(adsr.currentLevel, 1.0, ticksLeft)
+		// update segment
+		adsr.startSegment(adsr.currentLevel, 1.0, ticksLeft)
 	}
 }
+func (adsr *adsrEnvelope) setHold(holdTimeInSeconds float64) {
+	adsr.stage[adsrHoldStage] = math.Floor(math.Max(holdTimeInSeconds*adsr.sampleRate, 0.0))
+}
 func (adsr *adsrEnvelope) setDecay(decayTimeInSeconds float64) {
 	decayTimeInFrames := math.Floor(math.Max(decayTimeInSeconds*adsr.sampleRate, 0.0))
 	adsr.stage[adsrDecayStage] = decayTimeInFrames
-	// [edge case] if we're in the same stage currently, fix the multiplier
+	// [edge case] if we're in the same stage currently, fix the segment
 	if adsr.currentStage == adsrDecayStage {
 		// calculate the discrepancy of ticks left to compute
 		ticksLeft := decayTimeInFrames - float64(adsr.currentTick)
-		// update multipler
-		adsr.multiplier = calculateLevelMultiplier(adsr.currentLevel, adsr.stage[adsrSustainStage], ticksLeft)
+		// update segment
+		adsr.startSegment(adsr.currentLevel, adsr.stage[adsrSustainStage], ticksLeft)
 	}
 }
 func (adsr *adsrEnvelope) setSustain(sustainLevel float64) {
@@ -78,12 +137,11 @@ func (adsr *adsrEnvelope) setSustain(sustainLevel float64) {
 	// [edge case] if we're in the decay/sustain stages
 	switch adsr.currentStage {
 	case adsrDecayStage:
-		// update multipler (as we're altering the slope of the decay)
+		// update segment (as we're altering the slope of the decay)
 		// calculate the discrepancy of ticks left to compute
 		ticksLeft := adsr.stage[adsrDecayStage] - float64(adsr.currentTick)
-		// update multipler
-		adsr.multiplier =
-			calculateLevelMultiplier(adsr.currentLevel, sl, ticksLeft)
+		// update segment
+		adsr.startSegment(adsr.currentLevel, sl, ticksLeft)
 	case adsrSustainStage:
 		// update currentLevel
 		adsr.currentLevel = sl
@@ -92,36 +150,141 @@ func (adsr *adsrEnvelope) setSustain(sustainLevel float64) {
 func (adsr *adsrEnvelope) setRelease(releaseTimeInSeconds float64) {
 	releaseTimeInFrames := math.Floor(math.Max(releaseTimeInSeconds*adsr.sampleRate, 0.0))
 	adsr.stage[adsrReleaseStage] = releaseTimeInFrames
-	// [edge case] if we're in the same stage currently, fix the multiplier
+	// [edge case] if we're in the same stage currently, fix the segment
 	if adsr.currentStage == adsrReleaseStage {
 		// calculate the discrepancy of ticks left to compute
 		ticksLeft := releaseTimeInFrames - float64(adsr.currentTick)
-		// update multipler
-		adsr.multiplier = calculateLevelMultiplier(adsr.currentLevel, adsrMinimumLevel, ticksLeft)
+		// update segment
+		adsr.startSegment(adsr.currentLevel, adsrMinimumLevel, ticksLeft)
 	}
 }
 
-// immediately enter the attack stage from the beginning
-// this is also for (re)triggering the adsr envelope
-func (adsr *adsrEnvelope) attack() {
-	// update current stage, update the multiplier, and reset current tick
-	adsr.currentStage = adsrAttackStage
+// set the curve of a stage (attack, decay, or release), the tension only
+// matters for a TensionCurve
+func (adsr *adsrEnvelope) setCurve(stage int, curve EnvelopeCurve, tension float64) {
+	adsr.curve[stage] = curve
+	adsr.tension[stage] = math.Max(-1.0, math.Min(tension, 1.0))
+	// [edge case] if we're in the same stage currently, fix the segment
+	if adsr.currentStage == stage {
+		ticksLeft := adsr.stage[stage] - float64(adsr.currentTick)
+		adsr.startSegment(adsr.currentLevel, adsr.targetLevel(stage), ticksLeft)
+	}
+}
+
+// the level a (segment) stage ends at
+func (adsr *adsrEnvelope) targetLevel(stage int) float64 {
+	switch stage {
+	case adsrAttackStage:
+		return 1.0
+	case adsrDecayStage:
+		return adsr.stage[adsrSustainStage]
+	default:
+		return adsrMinimumLevel
+	}
+}
+
+// start a segment (of the current stage's curve) which moves from the start
+// level to the target level in a number of frames
+func (adsr *adsrEnvelope) startSegment(startLevel, targetLevel, numberOfFrames float64) {
+	n := math.Max(numberOfFrames, 1.0)
+	adsr.curveValue = startLevel
+	adsr.multiplier = 1.0
+	adsr.increment = 0.0
+	adsr.offset = 0.0
+	adsr.scale = 1.0
+
+	curve := adsr.curve[adsr.currentStage]
+	tension := adsr.tension[adsr.currentStage] * adsrMaximumTension
+	if curve == TensionCurve && tension == 0.0 {
+		curve = LinearCurve
+	}
+
+	switch curve {
+	case LinearCurve:
+		adsr.increment = (targetLevel - startLevel) / n
+	case LogarithmicCurve:
+		// exponential from the target back to the start, flipped:
+		// start + target - target*(start/target)^t
+		adsr.curveValue = targetLevel
+		adsr.multiplier = math.Pow(startLevel/targetLevel, 1.0/n)
+		adsr.offset = startLevel + targetLevel
+		adsr.scale = -1.0
+	case TensionCurve:
+		// start + (target - start) * (e^(tension*t) - 1)/(e^tension - 1)
+		adsr.curveValue = 1.0
+		adsr.multiplier = math.Exp(tension / n)
+		adsr.scale = (targetLevel - startLevel) / (math.Exp(tension) - 1.0)
+		adsr.offset = startLevel - adsr.scale
+	default: // ExponentialCurve
+		adsr.multiplier = calculateLevelMultiplier(startLevel, targetLevel, n)
+	}
+}
+
+// enter a stage from the beginning
+func (adsr *adsrEnvelope) enterStage(stage int) {
+	adsr.currentStage = stage
 	adsr.currentTick = 0
-	adsr.multiplier = calculateLevelMultiplier(
-		adsrMinimumLevel,
-		1.0,
-		adsr.stage[adsrAttackStage])
+	// segments start from the current level
+	switch stage {
+	case adsrAttackStage, adsrDecayStage, adsrReleaseStage, adsrDeclickStage:
+		adsr.startSegment(adsr.currentLevel, adsr.targetLevel(stage), adsr.stage[stage])
+	}
 }
 
-// immediately enter the release stage from the beginning
+// enter the attack stage (or the delay stage before it)
+func (adsr *adsrEnvelope) enterAttack() {
+	if adsr.stage[adsrDelayStage] > 0 {
+		adsr.enterStage(adsrDelayStage)
+	} else {
+		adsr.enterStage(adsrAttackStage)
+	}
+}
+
+// immediately enter the attack stage (or the delay stage before it) from the
+// beginning.  this is also for (re)triggering the adsr envelope, according to
+// its retrigger mode
+func (adsr *adsrEnvelope) attack() {
+	switch adsr.retriggerMode {
+	case RetriggerReset:
+		// fade to silence first (if we're not there already)
+		if adsr.currentLevel > adsrMinimumLevel {
+			adsr.enterStage(adsrDeclickStage)
+			return
+		}
+	case RetriggerLegato:
+		// keep going if we're sounding
+		if adsr.currentStage != adsrOffStage && adsr.currentStage != adsrReleaseStage {
+			return
+		}
+	}
+	adsr.enterAttack()
+}
+
+func (adsr *adsrEnvelope) setRetriggerMode(retriggerMode RetriggerMode) {
+	adsr.retriggerMode = retriggerMode
+}
+
+// immediately enter the release stage from the beginning (from the current
+// level)
 func (adsr *adsrEnvelope) release() {
-	// update current stage, update the multiplier, and reset current tick
-	adsr.currentStage = adsrReleaseStage
-	adsr.currentTick = 0
-	adsr.multiplier = calculateLevelMultiplier(
-		adsr.stage[adsrSustainStage],
-		adsrMinimumLevel,
-		adsr.stage[adsrReleaseStage])
+	adsr.enterStage(adsrReleaseStage)
+}
+
+// change the sample rate, keeping the stage durations (and how far through
+// the current stage we are) the same in seconds
+func (adsr *adsrEnvelope) setSampleRate(sampleRate float64) {
+	ratio := sampleRate / adsr.sampleRate
+	adsr.sampleRate = sampleRate
+	for _, stage := range []int{adsrDelayStage, adsrAttackStage, adsrHoldStage, adsrDecayStage, adsrReleaseStage, adsrDeclickStage} {
+		adsr.stage[stage] = math.Floor(adsr.stage[stage] * ratio)
+	}
+	adsr.currentTick = int(float64(adsr.currentTick) * ratio)
+	// continue the current segment (from the current level)
+	switch adsr.currentStage {
+	case adsrAttackStage, adsrDecayStage, adsrReleaseStage, adsrDeclickStage:
+		ticksLeft := adsr.stage[adsr.currentStage] - float64(adsr.currentTick)
+		adsr.startSegment(adsr.currentLevel, adsr.targetLevel(adsr.currentStage), ticksLeft)
+	}
 }
 
 // a callback which runs when the release stage finishes
@@ -145,14 +308,20 @@ func newADSREnvelope(
 	// create an adsr object (unspecifed attack/decay/sustain/release, that
 	// will be set below)
 	adsr := &adsrEnvelope{
-		sampleRate:   sampleRate,
-		currentLevel: adsrMinimumLevel,
-		multiplier:   1.0,
+		sampleRate:    sampleRate,
+		currentLevel:  adsrMinimumLevel,
+		multiplier:    1.0,
+		retriggerMode: RetriggerFromCurrentLevel,
 	}
-	// create the stage values
-	adsr.stage = make([]float64, 5)
+	// create the stage values (and their curves, exponential by default)
+	adsr.stage = make([]float64, adsrNumberOfStages)
+	adsr.curve = make([]EnvelopeCurve, adsrNumberOfStages)
+	adsr.tension = make([]float64, adsrNumberOfStages)
 	// set the off stage value
 	adsr.stage[adsrOffStage] = adsrMinimumLevel
+	// the de-click fade is short and linear
+	adsr.stage[adsrDeclickStage] = math.Floor(adsrDeclickInSeconds * sampleRate)
+	adsr.curve[adsrDeclickStage] = LinearCurve
 	// set the adsr times
 	adsr.setAttack(attackTimeInSeconds)
 	adsr.setDecay(decayTimeInSeconds)
@@ -174,38 +343,46 @@ func (adsr *adsrEnvelope) tick() float64 {
 	if adsr.currentStage != adsrOffStage && adsr.currentStage != adsrSustainStage {
 		// if there are ticks left in this stage
 		if float64(adsr.currentTick) < adsr.stage[adsr.currentStage] {
-			// adjust the current level by multiplier and increment
-			// the current tick.  NB. at this point we're only
-			// within the attack, decay, release stage)
-			adsr.currentLevel *= adsr.multiplier
+			// adjust the current level along the segment and
+			// increment the current tick.  NB. the delay and hold
+			// stages keep their level
+			if adsr.currentStage != adsrDelayStage && adsr.currentStage != adsrHoldStage {
+				adsr.curveValue = adsr.curveValue*adsr.multiplier + adsr.increment
+				adsr.currentLevel = adsr.offset + adsr.scale*adsr.curveValue
+			}
 			adsr.currentTick += 1
 		} else {
-			// reset the current tick
-			adsr.currentTick = 0
 			// find which stage is next (given the current)
 			switch adsr.currentStage {
+			case adsrDeclickStage:
+				// de-click -> (delay ->) attack
+				adsr.currentLevel = adsrMinimumLevel
+				adsr.enterAttack()
+			case adsrDelayStage:
+				// delay -> attack
+				adsr.enterStage(adsrAttackStage)
 			case adsrAttackStage:
-				// attack -> decay
-				adsr.currentStage = adsrDecayStage
-				// update the multiplier (for decay stage)
-				adsr.multiplier = calculateLevelMultiplier(
-					1.0,
-					adsr.stage[adsrSustainStage],
-					adsr.stage[adsrDecayStage])
 				// NB, when adsr attack time is very small
 				// (around 0s) the attack stage does not have
 				// sufficient duration to ramp up the peak adsr
 				// level (of 1.) hence we just set it to 1 now.
 				adsr.currentLevel = 1.0
+				// attack -> hold (if there is one) -> decay
+				if adsr.stage[adsrHoldStage] > 0 {
+					adsr.enterStage(adsrHoldStage)
+				} else {
+					adsr.enterStage(adsrDecayStage)
+				}
+			case adsrHoldStage:
+				// hold -> decay
+				adsr.enterStage(adsrDecayStage)
 			case adsrDecayStage:
 				// decay -> sustain
-				adsr.currentStage = adsrSustainStage
-				// update the multiplier (for sustain stage)
-				adsr.multiplier = adsr.stage[adsrSustainStage]
+				adsr.enterStage(adsrSustainStage)
+				adsr.currentLevel = adsr.stage[adsrSustainStage]
 			case adsrReleaseStage:
 				// release -> off
-				adsr.currentStage = adsrOffStage
-				// update the multiplier (for off stage)
+				adsr.enterStage(adsrOffStage)
 				adsr.currentLevel = adsrMinimumLevel
 				// run the done action
 				if adsr.doneAction != nil {
@@ -217,15 +394,18 @@ func (adsr *adsrEnvelope) tick() float64 {
 			}
 		}
 	}
+	// the off stage is silent (its level is only kept as where the next
+	// attack starts from)
+	if adsr.currentStage == adsrOffStage {
+		return 0.0
+	}
 	return adsr.currentLevel
 }
 
 // calculate the multiplier to increase/decrease
 // the current level in an exponential manner
+// NB. it's exact (rather than 1 + log(target/start)/n, which falls short of
+// the target, leaving a jump at the end of the segment)
 func calculateLevelMultiplier(startLevel, targetLevel, numberOfFrames float64) float64 {
-	if numberOfFrames < 1.0 {
-		return 1.0 + (math.Log(targetLevel) - math.Log(startLevel))
-	} else {
-		return 1.0 + (math.Log(targetLevel)-math.Log(startLevel))/numberOfFrames
-	}
+	return math.Exp((math.Log(targetLevel) - math.Log(startLevel)) / math.Max(numberOfFrames, 1.0))
 }
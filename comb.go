@@ -0,0 +1,87 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// a tuned feedback comb filter
+//
+// The input is fed back through a delay line of 1 period of the cutoff
+// frequency, which resonates at that frequency and its harmonics (metallic
+// resonances, or karplus-strong like plucks when excited by short sounds).
+// The resonance sets the feedback, and a gentle lowpass inside the feedback
+// loop makes the upper harmonics die away sooner (like a plucked string).
+//
+// more info here:
+// https://ccrma.stanford.edu/~jos/pasp/Feedback_Comb_Filters.html
+
+const (
+	// the lowest frequency the comb can be tuned to (sizing its delay line)
+	combMinimumFrequencyInHz float64 = 20.0
+	// the feedback at full resonance
+	combMaximumFeedback float64 = 0.999
+	// how much the lowpass (in the feedback loop) damps
+	combDamping float64 = 0.2
+)
+
+type comb struct {
+	// the delay line (circular buffer), and where it's written next
+	delayLine  []float64
+	writeIndex int
+	// the delay (in frames, fractional) and feedback
+	delay, feedback float64
+	// the state of the lowpass (in the feedback loop)
+	damped float64
+}
+
+// allocate the delay line (once)
+func (c *comb) allocate(sampleRate float64) {
+	if c.delayLine == nil {
+		c.delayLine = make([]float64, int(sampleRate/combMinimumFrequencyInHz)+2)
+	}
+}
+
+// set the coefficients from the frequency (relative to the sample rate) and
+// resonance (0 to 1)
+func (c *comb) setCoefficients(frequency, resonance float64) {
+	c.delay = 1.0
+	if frequency > 0.0 {
+		c.delay = math.Max(1.0, math.Min(1.0/frequency, float64(len(c.delayLine)-2)))
+	}
+	c.feedback = combMaximumFeedback * resonance
+}
+
+// filter the input
+func (c *comb) tick(input float64) float64 {
+	if c.delayLine == nil {
+		return input
+	}
+	n := len(c.delayLine)
+
+	// read the delay line (interpolating linearly)
+	position := float64(c.writeIndex) - c.delay
+	if position < 0 {
+		position += float64(n)
+	}
+	i := int(position)
+	frac := position - float64(i)
+	delayed := c.delayLine[i%n] + frac*(c.delayLine[(i+1)%n]-c.delayLine[i%n])
+
+	// damp it, feed it back
+	c.damped += (1.0 - combDamping) * (delayed - c.damped)
+	output := input + c.feedback*c.damped
+	c.delayLine[c.writeIndex] = output
+	c.writeIndex = (c.writeIndex + 1) % n
+
+	// the resonant peaks (which have gain 1/(1 - feedback)) are kept at
+	// about unity
+	return output * (1.0 - c.feedback)
+}
+
+// silence the delay line
+func (c *comb) reset() {
+	for i := range c.delayLine {
+		c.delayLine[i] = 0.0
+	}
+	c.damped = 0.0
+}
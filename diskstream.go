@@ -0,0 +1,422 @@
+package stereophonic
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkb218/gosndfile/sndfile"
+)
+
+// disk streaming of (very) long sound files
+//
+// A regular table holds an entire sound file in memory (8 bytes per sample,
+// so an hour long stereo file is over 2GB).  A streaming table instead only
+// preloads the head of the sound file.  Each tablePlayer created from a
+// streaming table gets its own diskReader, which reads ahead of the current
+// playback position (on a background goroutine) into a ring buffer.
+//
+// The audio thread never blocks on the disk.  If a frame isn't available
+// (yet) it's read as silence, and the reader goroutine is notified to catch
+// up.  To avoid such dropouts when playback jumps (looping, slices, reverse)
+// the reader also keeps small "cue" blocks of audio around the slice and loop
+// points of its tablePlayer.
+
+const (
+	// how much of a streaming sound file is held in memory permanently
+	diskStreamPreloadInSeconds float64 = 2.0
+	// how much audio each diskReader buffers ahead of playback
+	diskStreamBufferInSeconds float64 = 2.0
+	// how much audio is cached around each cue (slice/loop) point
+	diskStreamCueInSeconds float64 = 0.25
+	// how many frames the reader goroutine reads from disk in one go
+	diskStreamChunkFrames int = 4096
+	// how many frames (behind playback) remain readable in the ring buffer
+	diskStreamMarginFrames int = 64
+	// how often the reader goroutine checks for work (if not notified)
+	diskStreamPollInterval = 10 * time.Millisecond
+)
+
+const (
+	diskCueStart int = iota
+	diskCueEnd
+	diskCueLoopStart
+	diskCueLoopEnd
+	//
+	diskNumberOfCues
+)
+
+var (
+	errorUnsupportedStreamingChannels error = fmt.Errorf("streaming tables must be mono or stereo")
+)
+
+// create a new streaming table from a sound file
+// only the head of the file is loaded into memory
+func newStreamingTable(soundFileName string) (*table, error) {
+
+	var info sndfile.Info
+
+	// try to open the sound file
+	sf, err := sndfile.Open(soundFileName, sndfile.Read, &info)
+	if err != nil {
+		return nil, err
+	}
+	defer sf.Close()
+
+	channels := int(sf.Format.Channels)
+	if channels < 1 || channels > 2 {
+		return nil, errorUnsupportedStreamingChannels
+	}
+
+	// preload the head of the sound file
+	nFrames := int(sf.Format.Frames)
+	nPreloadFrames := int(diskStreamPreloadInSeconds * float64(sf.Format.Samplerate))
+	if nPreloadFrames > nFrames {
+		nPreloadFrames = nFrames
+	}
+	samples := make([]float64, nPreloadFrames*channels)
+	framesRead, err := sf.ReadFrames(samples)
+	if err != nil {
+		return nil, err
+	}
+
+	return &table{
+		name:           soundFileName,
+		channels:       channels,
+		sampleRate:     float64(sf.Format.Samplerate),
+		samples:        samples,
+		nFrames:        nFrames,
+		streaming:      true,
+		nPreloadFrames: int(framesRead),
+		metadata:       readSampleMetadata(soundFileName),
+	}, nil
+}
+
+// a block of audio cached around a cue point
+type diskCue struct {
+	// the cue point this block was loaded around, or -1 when the block is
+	// invalid (being loaded).  Accessed atomically, from and to are only
+	// valid for a loaded point.
+	point    int64
+	from, to int
+	samples  []float64
+}
+
+// diskReader reads a streaming table (from disk) for 1 tablePlayer
+type diskReader struct {
+	// these are accessed atomically (and kept first for 64 bit alignment)
+	//
+	// frames [windowStart, windowEnd) are readable in the ring
+	windowStart, windowEnd int64
+	// the last frame requested by the audio thread
+	position int64
+	// the cue points requested by the tablePlayer
+	cuePoints [diskNumberOfCues]int64
+	// 1 when playback is reversed (the ring then fills backwards)
+	reversed int32
+
+	fileName string
+	channels int
+	nFrames  int
+	// ring buffer of interleaved samples, holding capacity frames
+	ring     []float64
+	capacity int
+	// cached audio around the cue points
+	cues [diskNumberOfCues]diskCue
+	// scratch buffer for reading from disk
+	chunk []float64
+	// where the sound file is currently positioned (avoids redundant seeks)
+	filePosition int
+	// notifies the reader goroutine there's work (buffered with size 1)
+	wake chan struct{}
+	// closed to stop the reader goroutine
+	done                chan struct{}
+	startOnce, stopOnce sync.Once
+}
+
+func newDiskReader(t *table) *diskReader {
+	capacity := int(diskStreamBufferInSeconds * t.sampleRate)
+	if capacity < 2*diskStreamChunkFrames {
+		capacity = 2 * diskStreamChunkFrames
+	}
+	cueFrames := int(diskStreamCueInSeconds * t.sampleRate)
+	d := &diskReader{
+		fileName:     t.name,
+		channels:     t.channels,
+		nFrames:      t.nFrames,
+		ring:         make([]float64, capacity*t.channels),
+		capacity:     capacity,
+		chunk:        make([]float64, diskStreamChunkFrames*t.channels),
+		filePosition: -1,
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	for c := range d.cues {
+		d.cues[c].point = -1
+		d.cues[c].samples = make([]float64, 2*cueFrames*t.channels)
+		d.cuePoints[c] = -1
+	}
+	// begin reading right after the preloaded head of the table
+	d.position = int64(t.nPreloadFrames)
+	d.windowStart = int64(t.nPreloadFrames)
+	d.windowEnd = int64(t.nPreloadFrames)
+	return d
+}
+
+// start the reader goroutine (only the first call has any effect)
+func (d *diskReader) start() {
+	d.startOnce.Do(func() {
+		go d.run()
+	})
+}
+
+// stop the reader goroutine, this never blocks so it's safe to call from the
+// audio thread (only the first call has any effect)
+func (d *diskReader) stop() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+	})
+}
+
+// inform the reader goroutine there's work to do (without blocking)
+func (d *diskReader) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// set the points the tablePlayer may jump to
+func (d *diskReader) setCues(start, end, loopStart, loopEnd int) {
+	atomic.StoreInt64(&d.cuePoints[diskCueStart], int64(start))
+	atomic.StoreInt64(&d.cuePoints[diskCueEnd], int64(end))
+	atomic.StoreInt64(&d.cuePoints[diskCueLoopStart], int64(loopStart))
+	atomic.StoreInt64(&d.cuePoints[diskCueLoopEnd], int64(loopEnd))
+	d.notify()
+}
+
+// set the direction in which the reader reads ahead
+func (d *diskReader) setReversed(isReversed bool) {
+	var reversed int32
+	if isReversed {
+		reversed = 1
+	}
+	atomic.StoreInt32(&d.reversed, reversed)
+	d.notify()
+}
+
+// read a (stereo) frame, called from the audio thread
+// frames which aren't available (yet) are read as silence
+func (d *diskReader) frame(i int) (float64, float64) {
+	atomic.StoreInt64(&d.position, int64(i))
+
+	start := atomic.LoadInt64(&d.windowStart)
+	end := atomic.LoadInt64(&d.windowEnd)
+	if start <= int64(i) && int64(i) < end {
+		left, right := d.read(d.ring, i%d.capacity)
+		// the writer only overwrites a slot after moving the window
+		// past it, so if the window still holds i, what we read is good
+		if atomic.LoadInt64(&d.windowStart) <= int64(i) && int64(i) < atomic.LoadInt64(&d.windowEnd) {
+			// keep the reader goroutine ahead of us
+			if atomic.LoadInt32(&d.reversed) == 1 {
+				if int64(i)-start < int64(d.capacity/2) {
+					d.notify()
+				}
+			} else if end-int64(i) < int64(d.capacity/2) {
+				d.notify()
+			}
+			return left, right
+		}
+	}
+
+	// otherwise try the cue blocks
+	for c := range d.cues {
+		cue := &d.cues[c]
+		point := atomic.LoadInt64(&cue.point)
+		if point < 0 || i < cue.from || cue.to <= i {
+			continue
+		}
+		left, right := d.read(cue.samples, i-cue.from)
+		if atomic.LoadInt64(&cue.point) == point {
+			d.notify()
+			return left, right
+		}
+	}
+
+	// underrun
+	d.notify()
+	return 0.0, 0.0
+}
+
+// read the nth (stereo) frame of interleaved samples
+func (d *diskReader) read(samples []float64, n int) (float64, float64) {
+	if d.channels == 1 {
+		return samples[n], samples[n]
+	}
+	return samples[2*n], samples[2*n+1]
+}
+
+// the reader goroutine
+func (d *diskReader) run() {
+
+	var info sndfile.Info
+
+	// each reader opens its own handle of the sound file (as they're
+	// positioned independently).  If it fails, the remainder of the table
+	// after the preloaded head just plays silence.
+	sf, err := sndfile.Open(d.fileName, sndfile.Read, &info)
+	if err != nil {
+		return
+	}
+	defer sf.Close()
+
+	ticker := time.NewTicker(diskStreamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.fillCues(sf)
+		d.fillRing(sf)
+		select {
+		case <-d.done:
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// (re)load the cue blocks whose cue points have changed
+func (d *diskReader) fillCues(sf *sndfile.File) {
+	for c := range d.cues {
+		cue := &d.cues[c]
+		point := atomic.LoadInt64(&d.cuePoints[c])
+		if point < 0 || point == atomic.LoadInt64(&cue.point) {
+			continue
+		}
+		// invalidate the block while it's being loaded
+		atomic.StoreInt64(&cue.point, -1)
+		n := len(cue.samples) / d.channels
+		from := clampInt(int(point)-n/2, 0, d.nFrames)
+		to := clampInt(from+n, 0, d.nFrames)
+		if !d.readFrames(sf, cue.samples, from, to) {
+			continue
+		}
+		cue.from = from
+		cue.to = to
+		atomic.StoreInt64(&cue.point, point)
+	}
+}
+
+// keep the ring buffer filled ahead of the playback position
+func (d *diskReader) fillRing(sf *sndfile.File) {
+
+	var (
+		position = int(atomic.LoadInt64(&d.position))
+		reversed = atomic.LoadInt32(&d.reversed) == 1
+		start    = int(atomic.LoadInt64(&d.windowStart))
+		end      = int(atomic.LoadInt64(&d.windowEnd))
+	)
+
+	// if playback jumped outside the window, discard it and start over
+	if position < start || end < position {
+		anchor := position
+		if reversed {
+			anchor = clampInt(position+1, 0, d.nFrames)
+		}
+		// NB. shrink the window before moving it so the audio thread
+		// never sees frames that don't belong to it
+		atomic.StoreInt64(&d.windowEnd, int64(start))
+		atomic.StoreInt64(&d.windowStart, int64(anchor))
+		atomic.StoreInt64(&d.windowEnd, int64(anchor))
+		start, end = anchor, anchor
+	}
+
+	if reversed {
+		// fill backwards from the window start
+		low := clampInt(position-d.capacity+diskStreamMarginFrames, 0, d.nFrames)
+		for start > low {
+			n := minInt(diskStreamChunkFrames, start-low)
+			from := start - n
+			// give up the frames which share ring slots with [from, start)
+			if end > from+d.capacity {
+				end = from + d.capacity
+				atomic.StoreInt64(&d.windowEnd, int64(end))
+			}
+			if !d.readIntoRing(sf, from, start) {
+				return
+			}
+			start = from
+			atomic.StoreInt64(&d.windowStart, int64(start))
+		}
+	} else {
+		// fill forwards from the window end
+		high := clampInt(position+d.capacity-diskStreamMarginFrames, 0, d.nFrames)
+		for end < high {
+			to := end + minInt(diskStreamChunkFrames, high-end)
+			// give up the frames which share ring slots with [end, to)
+			if start < to-d.capacity {
+				start = to - d.capacity
+				atomic.StoreInt64(&d.windowStart, int64(start))
+			}
+			if !d.readIntoRing(sf, end, to) {
+				return
+			}
+			end = to
+			atomic.StoreInt64(&d.windowEnd, int64(end))
+		}
+	}
+}
+
+// read frames [from, to) into their slots of the ring buffer
+func (d *diskReader) readIntoRing(sf *sndfile.File, from, to int) bool {
+	chunk := d.chunk[:(to-from)*d.channels]
+	if !d.readFrames(sf, chunk, from, to) {
+		return false
+	}
+	for f := from; f < to; f++ {
+		slot := f % d.capacity
+		copy(d.ring[slot*d.channels:(slot+1)*d.channels],
+			chunk[(f-from)*d.channels:(f-from+1)*d.channels])
+	}
+	return true
+}
+
+// read frames [from, to) of the sound file into samples
+func (d *diskReader) readFrames(sf *sndfile.File, samples []float64, from, to int) bool {
+	if d.filePosition != from {
+		if _, err := sf.Seek(int64(from), sndfile.Set); err != nil {
+			d.filePosition = -1
+			return false
+		}
+	}
+	framesRead, err := sf.ReadFrames(samples[:(to-from)*d.channels])
+	if err != nil {
+		d.filePosition = -1
+		return false
+	}
+	d.filePosition = from + int(framesRead)
+	// zero whatever couldn't be read (a truncated file perhaps)
+	for s := int(framesRead) * d.channels; s < (to-from)*d.channels; s++ {
+		samples[s] = 0.0
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// clamp x such that low <= x <= high
+func clampInt(x, low, high int) int {
+	if x < low {
+		return low
+	}
+	if x > high {
+		return high
+	}
+	return x
+}
@@ -0,0 +1,316 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+)
+
+// destructive editing of tables
+//
+// Tables are (essentially) immutable, so editing the table in a slot creates
+// a new version of it, which then replaces the old one in the slot.  Playback
+// events which are already playing the old version keep playing it (they
+// hold their own reference to it), only new events play the new version.
+//
+// The metadata (loops, markers) of an edited table moves along with its
+// frames.  Slices don't, edits which move frames (trimming, cropping,
+// reversing, concatenating) discard them.
+
+var (
+	errorUnsupportedEdit error = fmt.Errorf("unsupported edit for this table")
+	errorInvalidEdit     error = fmt.Errorf("invalid edit (it would leave no frames)")
+)
+
+// replace the table in a slot with an edited version of it
+func (e *Engine) editTable(slot int, edit func(t *table) (*table, error)) error {
+	e.Lock()
+	defer e.Unlock()
+
+	t, err := e.inMemoryTable(slot)
+	if err != nil {
+		return err
+	}
+
+	// NB. there's no need to lock the table, as tables in slots are only
+	// ever changed with the engine locked
+	edited, err := edit(t)
+	if err != nil {
+		return err
+	}
+	if edited.nFrames < 1 {
+		return errorInvalidEdit
+	}
+
+	e.tables[slot] = edited
+	// the original can't be re-converted (without losing the edit)
+	delete(e.originalTables, slot)
+
+	return nil
+}
+
+// create a new version of the table with new samples (and channels)
+// keeping its metadata and slices, as long as the frames haven't moved
+func (b *table) edited(samples []float64, channels int) *table {
+	return &table{
+		name:       b.name,
+		channels:   channels,
+		sampleRate: b.sampleRate,
+		samples:    samples,
+		nFrames:    len(samples) / channels,
+		metadata:   b.metadata,
+		slices:     b.slices,
+	}
+}
+
+// create a new version of the table where frames [start, end] are kept
+func (b *table) cropped(start, end int) *table {
+	samples := make([]float64, (end-start+1)*b.channels)
+	copy(samples, b.samples[start*b.channels:(end+1)*b.channels])
+	t := b.edited(samples, b.channels)
+	t.metadata = b.metadata.moved(func(position int) int { return position - start }, t.nFrames)
+	t.slices = nil
+	return t
+}
+
+// move the positions of the metadata, discarding what moves out of the table
+func (m SampleMetadata) moved(move func(position int) int, nFrames int) SampleMetadata {
+	moved := m
+	moved.Loops = nil
+	for _, loop := range m.Loops {
+		start, end := move(loop.Start), move(loop.End)
+		if start > end {
+			start, end = end, start
+		}
+		if start >= 0 && end < nFrames {
+			moved.Loops = append(moved.Loops, SampleLoop{Start: start, End: end, Type: loop.Type})
+		}
+	}
+	moved.Markers = nil
+	for _, marker := range m.Markers {
+		if position := move(marker.Position); position >= 0 && position < nFrames {
+			moved.Markers = append(moved.Markers, SampleMarker{Position: position, Name: marker.Name})
+		}
+	}
+	return moved
+}
+
+// the largest absolute sample in the table
+func (b *table) peak() float64 {
+	peak := 0.0
+	for _, sample := range b.samples {
+		peak = math.Max(peak, math.Abs(sample))
+	}
+	return peak
+}
+
+// create a new version of the table with each sample mapped by f
+// (f is given the sample, its frame, and its channel)
+func (b *table) mapped(f func(sample float64, frame, channel int) float64) *table {
+	samples := make([]float64, len(b.samples))
+	for i, sample := range b.samples {
+		samples[i] = f(sample, i/b.channels, i%b.channels)
+	}
+	return b.edited(samples, b.channels)
+}
+
+// normalize the sound file in a slot so its peak is at peakInDecibels (0dBFS)
+func (e *Engine) Normalize(slot int, peakInDecibels float64) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		peak := t.peak()
+		if peak == 0 {
+			return t.edited(t.samples, t.channels), nil
+		}
+		a := decibelsToAmplitude(peakInDecibels) / peak
+		return t.mapped(func(sample float64, frame, channel int) float64 {
+			return sample * a
+		}), nil
+	})
+}
+
+// apply a gain (in decibels) to the sound file in a slot
+func (e *Engine) ApplyGain(slot int, db float64) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		a := decibelsToAmplitude(db)
+		return t.mapped(func(sample float64, frame, channel int) float64 {
+			return sample * a
+		}), nil
+	})
+}
+
+// trim the silence (frames quieter than thresholdInDecibels) from the start
+// and end of the sound file in a slot
+func (e *Engine) TrimSilence(slot int, thresholdInDecibels float64) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		threshold := decibelsToAmplitude(thresholdInDecibels)
+		isSilent := func(frame int) bool {
+			for c := 0; c < t.channels; c++ {
+				if math.Abs(t.samples[frame*t.channels+c]) > threshold {
+					return false
+				}
+			}
+			return true
+		}
+		start, end := 0, t.nFrames-1
+		for start < end && isSilent(start) {
+			start++
+		}
+		for end > start && isSilent(end) {
+			end--
+		}
+		return t.cropped(start, end), nil
+	})
+}
+
+// fade in (linearly) the start of the sound file in a slot
+func (e *Engine) FadeIn(slot int, durationInSeconds float64) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		n := durationInSeconds * t.sampleRate
+		return t.mapped(func(sample float64, frame, channel int) float64 {
+			if float64(frame) < n {
+				return sample * float64(frame) / n
+			}
+			return sample
+		}), nil
+	})
+}
+
+// fade out (linearly) the end of the sound file in a slot
+func (e *Engine) FadeOut(slot int, durationInSeconds float64) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		n := durationInSeconds * t.sampleRate
+		return t.mapped(func(sample float64, frame, channel int) float64 {
+			if remaining := float64(t.nFrames - 1 - frame); remaining < n {
+				return sample * remaining / n
+			}
+			return sample
+		}), nil
+	})
+}
+
+// reverse the sound file in a slot
+func (e *Engine) Reverse(slot int) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		samples := make([]float64, len(t.samples))
+		for frame := 0; frame < t.nFrames; frame++ {
+			copy(samples[(t.nFrames-1-frame)*t.channels:(t.nFrames-frame)*t.channels],
+				t.samples[frame*t.channels:(frame+1)*t.channels])
+		}
+		reversed := t.edited(samples, t.channels)
+		reversed.metadata = t.metadata.moved(func(position int) int { return t.nFrames - 1 - position }, t.nFrames)
+		reversed.slices = nil
+		return reversed, nil
+	})
+}
+
+// remove the dc offset (the average of each channel) of the sound file in a
+// slot
+func (e *Engine) RemoveDCOffset(slot int) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		average := make([]float64, t.channels)
+		for i, sample := range t.samples {
+			average[i%t.channels] += sample / float64(t.nFrames)
+		}
+		return t.mapped(func(sample float64, frame, channel int) float64 {
+			return sample - average[channel]
+		}), nil
+	})
+}
+
+// sum the channels of the sound file in a slot to mono
+func (e *Engine) SumToMono(slot int) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		samples := make([]float64, t.nFrames)
+		for i, sample := range t.samples {
+			samples[i/t.channels] += sample / float64(t.channels)
+		}
+		return t.edited(samples, 1), nil
+	})
+}
+
+// swap the left and right channels of the (stereo) sound file in a slot
+func (e *Engine) SwapChannels(slot int) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		if t.channels != 2 {
+			return nil, errorUnsupportedEdit
+		}
+		samples := make([]float64, len(t.samples))
+		for i := 0; i < len(samples); i += 2 {
+			samples[i], samples[i+1] = t.samples[i+1], t.samples[i]
+		}
+		return t.edited(samples, 2), nil
+	})
+}
+
+// crop the sound file in a slot to a selection
+// where start/end are in the range [0, 1) and start < end (like SetSlice())
+func (e *Engine) Crop(slot int, start, end float64) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		start = math.Min(math.Max(0, start), 1.0)
+		end = math.Min(math.Max(0, end), 1.0)
+		startFrame := int(float64(t.nFrames-1) * start)
+		endFrame := int(float64(t.nFrames-1) * end)
+		if startFrame >= endFrame {
+			return nil, errorInvalidEdit
+		}
+		return t.cropped(startFrame, endFrame), nil
+	})
+}
+
+// concatenate the sound files of other slots to the end of the sound file in
+// a slot.  They're converted to its sample rate, and mono is converted to
+// stereo (should their channels differ).
+func (e *Engine) Concatenate(slot int, otherSlots ...int) error {
+	return e.editTable(slot, func(t *table) (*table, error) {
+		others := make([]*table, len(otherSlots))
+		for n, otherSlot := range otherSlots {
+			other, err := e.inMemoryTable(otherSlot)
+			if err != nil {
+				return nil, err
+			}
+			others[n] = other
+		}
+
+		channels := t.channels
+		for _, other := range others {
+			if other.channels > channels {
+				channels = other.channels
+			}
+		}
+		if channels > 2 && channels != t.channels {
+			return nil, errorUnsupportedEdit
+		}
+
+		samples := upmixed(t.samples, t.channels, channels)
+		for _, other := range others {
+			if other.sampleRate != t.sampleRate {
+				resampled, err := other.resample(t.sampleRate)
+				if err != nil {
+					return nil, err
+				}
+				other = resampled
+			}
+			if other.channels != channels && other.channels != 1 {
+				return nil, errorUnsupportedEdit
+			}
+			samples = append(samples, upmixed(other.samples, other.channels, channels)...)
+		}
+
+		concatenated := t.edited(samples, channels)
+		concatenated.slices = nil
+		return concatenated, nil
+	})
+}
+
+// convert mono samples to (duplicated) stereo samples (if channels differ)
+func upmixed(samples []float64, channels, toChannels int) []float64 {
+	if channels == toChannels {
+		return append([]float64(nil), samples...)
+	}
+	upmixed := make([]float64, len(samples)*toChannels)
+	for i, sample := range samples {
+		for c := 0; c < toChannels; c++ {
+			upmixed[i*toChannels+c] = sample
+		}
+	}
+	return upmixed
+}
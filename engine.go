@@ -3,7 +3,10 @@ package stereophonic
 import (
 	"fmt"
 	"github.com/gordonklaus/portaudio"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -29,9 +32,15 @@ type Engine struct {
 	// the sample rate of the *stream* (not necessarily what you set it as)
 	// this is a necessary variable for many audio computations
 	streamSampleRate float64
+	// the stream parameters the stream was opened with (see Reconfigure())
+	openStreamParameters portaudio.StreamParameters
 	// mapping from a slot number -> sample (or as we call tables)
 	// this collates references to the loaded tables
 	tables map[int]*table
+	// the original tables of slots loaded with resampling (to the stream
+	// sample rate).  They're kept to re-convert the slots should the
+	// stream sample rate change (after a Stop(), SetSampleRate(), Start())
+	originalTables map[int]*table
 	// set (really a map, cuz golang has no set datatype) of (currently)
 	// active sources of audio.  the stream callback is constantly
 	// iterating the active playbackEvents calling tick() on each
@@ -44,8 +53,38 @@ type Engine struct {
 	initialized bool
 	// flag to check whether the portaudio stream started
 	started bool
+	// whether new playback events start with the metadata (loops, root
+	// note) of their sound file applied
+	applySampleMetadata bool
 	// gain for audio input (assuming there *is* an audio input device)
 	inputAmplitude float32
+	// the *recorder of the output (if recording, otherwise a nil
+	// *recorder).  It's an atomic.Value as the stream callback reads it
+	// without locking the engine
+	recorder atomic.Value
+	// the *sampler of the input (if sampling, otherwise a nil *sampler)
+	sampler atomic.Value
+	// level meters of the master output and the input (see meter.go)
+	masterMeter, inputMeter *meter
+	// the *SpectrumAnalyzer of the output (if analyzing, otherwise a nil
+	// *SpectrumAnalyzer)
+	spectrumAnalyzer atomic.Value
+	// performance statistics of the stream callback (see stats.go)
+	stats *engineStats
+	// the fade (out/in) of the output when reconfiguring (see
+	// reconfigure.go).  The gain and its increment belong to the stream
+	// callback, the flags are accessed atomically.
+	fadeGain, fadeIncrement float64
+	isFadingOut, isFadedOut int32
+	// the input, as a source (see input.go), and the (mono or stereo)
+	// input it's mapped to (belonging to the stream callback)
+	input       *InputSource
+	inputBuffer []float32
+	// the tempo (in beats per minute) and beats per bar (see tempo.go)
+	tempo       float64
+	beatsPerBar int
+	// the live looper (see looper.go)
+	looper *looper
 }
 
 // prepare an engine
@@ -76,19 +115,29 @@ func New() (*Engine, error) {
 	// we're requesting low latency parameters (gotta go fast)
 	streamParameters = portaudio.LowLatencyParameters(nil, defaultOutputDeviceInfo)
 
-	// stereo output is required for anything to work.  If it doesn't
-	// support stereo... well you'll find out when Start() is called.
-	streamParameters.Output.Channels = 2
+	// prefer stereo output (unless the device is mono), see
+	// SetOutputChannels() for more
+	if defaultOutputDeviceInfo != nil {
+		streamParameters.Output.Channels = preferredOutputChannels(defaultOutputDeviceInfo.MaxOutputChannels)
+	}
 
 	return &Engine{
 		streamParameters:     streamParameters, // <--- default configuration
 		stream:               nil,
 		tables:               map[int]*table{},
+		originalTables:       map[int]*table{},
 		activePlaybackEvents: map[*playbackEvent]bool{},
 		newPlaybackEvents:    make(chan *playbackEvent, 128), // <--- magic number
 		initialized:          true,
 		started:              false,
 		inputAmplitude:       float32(1.0), // 0db gain for audio input
+		masterMeter:          newMeter(streamParameters.SampleRate),
+		inputMeter:           newMeter(streamParameters.SampleRate),
+		stats:                newEngineStats(),
+		input:                newInputSource(streamParameters.SampleRate),
+		tempo:                defaultTempo,
+		beatsPerBar:          defaultBeatsPerBar,
+		looper:               newLooper(),
 	}, nil
 }
 
@@ -136,7 +185,8 @@ func (e *Engine) DefaultOutputDevice() *portaudio.DeviceInfo {
 // Nota Bene, regarding the sampleRate, framesPerBuffer, and Devices setters:
 // these setters *wont* show you whether the values set are acceptable.  They
 // only manifest *before* you call Start().  If you call them while the engine
-// is already started, they won't have any effect, you must Stop() the engine.
+// is already started, they won't have any effect until you Reconfigure() (or
+// Stop() and Start()) the engine.
 // if you call them after Close(), they will return an error and you must Reopen()
 
 // sets the stream sample rate
@@ -175,10 +225,11 @@ func (e *Engine) SetDevices(inputDeviceInfo, outputDeviceInfo *portaudio.DeviceI
 	// parameter values
 	streamParameters.SampleRate = e.streamParameters.SampleRate
 	streamParameters.FramesPerBuffer = e.streamParameters.FramesPerBuffer
-	// force stereo output.  NB, the output device *must* support stereo
-	// (otherwise this entire library will not work) if it doesn't support
-	// stereo, well, you'll find out when Start() is called won't you
-	streamParameters.Output.Channels = 2
+	// prefer stereo output (unless the device is mono), see
+	// SetOutputChannels() for more
+	if streamParameters.Output.Device != nil {
+		streamParameters.Output.Channels = preferredOutputChannels(streamParameters.Output.Device.MaxOutputChannels)
+	}
 	// if we acquired an input device
 	if streamParameters.Input.Device != nil {
 		// prefer stereo input (if it has >2 possible channels)
@@ -192,11 +243,12 @@ func (e *Engine) SetDevices(inputDeviceInfo, outputDeviceInfo *portaudio.DeviceI
 	return nil
 }
 
-// sets how many input channels we want our input device to read in.  It should
-// be noted however, currently *only* mono or stereo input is supported in the
-// stream callback (hence you can only enter values of 1 or 2 to this function,
-// anything else will error).  Furthermore, if there is currently no input
-// device, this function will also error.
+// sets how many input channels we want our input device to read in (from 1 to
+// the device's maximum).  The input source is mono or stereo, which channels
+// it uses are mapped with SetInputChannelMap() (by default the first 1 or 2),
+// and it's processed (gate, filter, pan) before it's played, see input.go.
+// Furthermore, if there is currently no input device, this function will also
+// error.
 func (e *Engine) SetInputChannels(numberOfChannels int) error {
 	if !e.initialized {
 		return errorEngineNotInitialized
@@ -205,10 +257,9 @@ func (e *Engine) SetInputChannels(numberOfChannels int) error {
 	if e.streamParameters.Input.Device == nil {
 		return errorDeviceDoesNotExist
 	}
-	// error if numberOfChannels is not mono or stereo
-	// or if we are assigning stereo to a mono only device
-	unsupportedNumberOfChannels := (numberOfChannels < 1) || (numberOfChannels > 2) ||
-		(numberOfChannels == 2 && e.streamParameters.Input.Device.MaxInputChannels == 1)
+	// error if numberOfChannels is more than the device has
+	unsupportedNumberOfChannels := (numberOfChannels < 1) ||
+		(numberOfChannels > e.streamParameters.Input.Device.MaxInputChannels)
 	if unsupportedNumberOfChannels {
 		return errorUnsupportedNumberOfChannels
 	}
@@ -240,6 +291,22 @@ func (e *Engine) Start() error {
 		return errorEngineAlreadyStarted
 	}
 
+	// the meters' ballistics depend on the sample rate
+	e.masterMeter.setSampleRate(e.streamParameters.SampleRate)
+	e.inputMeter.setSampleRate(e.streamParameters.SampleRate)
+	// and the input source's
+	e.input.setSampleRate(e.streamParameters.SampleRate)
+	// as does the load (and the statistics start afresh)
+	e.stats.setSampleRate(e.streamParameters.SampleRate)
+	e.stats.clear()
+	// no fade (see Reconfigure())
+	e.fadeGain = 1.0
+	atomic.StoreInt32(&e.isFadingOut, 0)
+	atomic.StoreInt32(&e.isFadedOut, 0)
+
+	// (the stream callback reads the stream parameters it was opened with)
+	e.openStreamParameters = e.streamParameters
+
 	// open a stream with prior specified stream parameters & our callback
 	stream, err := portaudio.OpenStream(e.streamParameters, e.streamCallback)
 	if err != nil {
@@ -254,13 +321,56 @@ func (e *Engine) Start() error {
 	e.started = true
 	// save a reference to the newly created stream
 	e.stream = stream
-	// save the stream's current sample rate
+	// save the stream's current sample rate (remembering the last one, in
+	// case converting the tables fails below)
+	previousStreamSampleRate := e.streamSampleRate
 	streamInfo := stream.Info()
 	e.streamSampleRate = streamInfo.SampleRate
+	e.stats.setSampleRate(e.streamSampleRate)
+	e.fadeIncrement = 1.0 / math.Max(reconfigureFadeInSeconds*e.streamSampleRate, 1.0)
+	// convert the tables which were loaded with resampling
+	// (if the stream sample rate differs from theirs)
+	// if that failed, don't leave a started engine behind playing tables at
+	// the wrong rate, stop (and close) the stream and report why
+	if err = e.resampleTables(); err != nil {
+		e.started = false
+		e.stream = nil
+		e.streamSampleRate = previousStreamSampleRate
+		if stopErr := stream.Stop(); stopErr != nil {
+			err = fmt.Errorf("%v (and stopping the stream failed: %v)", err, stopErr)
+		}
+		if closeErr := stream.Close(); closeErr != nil {
+			err = fmt.Errorf("%v (and closing the stream failed: %v)", err, closeErr)
+		}
+		return err
+	}
 	// return without error
 	return nil
 }
 
+// converts the tables (which were loaded with resampling) to the stream sample
+// rate, assuming it's changed since they were last converted
+func (e *Engine) resampleTables() error {
+	for slot, original := range e.originalTables {
+		if table, exists := e.tables[slot]; exists && table.sampleRate == e.streamSampleRate {
+			continue
+		}
+		table, err := original.resample(e.streamSampleRate)
+		if err != nil {
+			return err
+		}
+		// keep the slices of the previous conversion
+		if previous, exists := e.tables[slot]; exists {
+			factor := table.sampleRate / previous.sampleRate
+			for _, start := range previous.slices {
+				table.slices = append(table.slices, int(float64(start)*factor))
+			}
+		}
+		e.tables[slot] = table
+	}
+	return nil
+}
+
 // stop *and* close an audio stream
 func (e *Engine) Stop() error {
 	e.Lock()
@@ -298,6 +408,21 @@ func (e *Engine) Close() error {
 
 	var err error
 
+	// finish any recording in progress
+	if r, _ := e.recorder.Load().(*recorder); r != nil {
+		e.stopRecording()
+	}
+	// stop any spectrum analysis
+	if a, _ := e.spectrumAnalyzer.Load().(*SpectrumAnalyzer); a != nil {
+		e.stopSpectrumAnalysis()
+	}
+	// and stop any sampling in progress (NB. we can't wait for it to
+	// finish here, as it locks the engine to load its slot)
+	if s, _ := e.sampler.Load().(*sampler); s != nil {
+		e.sampler.Store((*sampler)(nil))
+		close(s.stop)
+	}
+
 	// first, check if the stream exists
 	// edge case call sequence of: New() -> [stream: nil], Close()
 	if e.stream != nil {
@@ -321,9 +446,28 @@ func (e *Engine) Close() error {
 		// stops/closes the stream after each call
 	}
 
-	// remove the active playing tables
+	// remove the active playing tables (and those queued to play)
+	// stopping any of their disk readers and spectrum analysis
+	for len(e.newPlaybackEvents) > 0 {
+		e.activePlaybackEvents[<-e.newPlaybackEvents] = true
+	}
+	for playbackEvent := range e.activePlaybackEvents {
+		if playbackEvent.stream != nil {
+			playbackEvent.stream.stop()
+		}
+		if a := playbackEvent.currentSpectrumAnalyzer(); a != nil {
+			a.close()
+		}
+	}
 	e.activePlaybackEvents = nil
 	e.activePlaybackEvents = map[*playbackEvent]bool{}
+	// and forget the loop (stopping its spectrum analysis)
+	if p := e.looper.loopEvent; p != nil {
+		if a := p.currentSpectrumAnalyzer(); a != nil {
+			a.close()
+		}
+	}
+	e.looper = newLooper()
 
 	// now try to turn off portaudio
 	if err := portaudio.Terminate(); err != nil {
@@ -362,7 +506,15 @@ func (e *Engine) Reopen() error {
 // loads a soundfile into a sample slot
 // (which internally just loads a table with the soundfile frames,
 // then saves a reference in the engine)
-func (e *Engine) Load(slot int, soundFileName string) error {
+//
+// optionally, the soundfile can be resampled (with high quality) to the stream
+// sample rate, which avoids the aliasing of converting it during playback.  If
+// the engine isn't started yet, conversion happens on Start(), and it happens
+// again whenever the engine restarts at a different sample rate.
+// ex:
+//  e.Load(1, "kick.wav")       // => played back at its own sample rate
+//  e.Load(1, "kick.wav", true) // => converted to the stream sample rate
+func (e *Engine) Load(slot int, soundFileName string, resampleToStreamRate ...bool) error {
 	e.Lock()
 	defer e.Unlock()
 
@@ -370,6 +522,39 @@ func (e *Engine) Load(slot int, soundFileName string) error {
 	if err != nil {
 		return err
 	}
+
+	// forget the original table of whatever was in this slot before
+	delete(e.originalTables, slot)
+
+	if resampleToStreamRate != nil && resampleToStreamRate[0] {
+		// keep the original, so it can be re-converted later
+		e.originalTables[slot] = table
+		// and convert it now if we know the stream sample rate
+		if e.started {
+			if table, err = table.resample(e.streamSampleRate); err != nil {
+				delete(e.originalTables, slot)
+				return err
+			}
+		}
+	}
+	e.tables[slot] = table
+
+	return nil
+}
+
+// loads a soundfile into a sample slot for disk streaming playback
+// only the head of the soundfile is held in memory, the rest is read from disk
+// as it plays (which is how you'd want to play very long soundfiles).
+// Streaming tables must be mono or stereo.
+func (e *Engine) LoadStreaming(slot int, soundFileName string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	table, err := newStreamingTable(soundFileName)
+	if err != nil {
+		return err
+	}
+	delete(e.originalTables, slot)
 	e.tables[slot] = table
 
 	return nil
@@ -386,6 +571,7 @@ func (e *Engine) Delete(slot int) error {
 	}
 	// otherwise safely delete the table at this slot
 	delete(e.tables, slot)
+	delete(e.originalTables, slot)
 
 	return nil
 }
@@ -405,6 +591,15 @@ func (e *Engine) Delete(slot int) error {
 func (e *Engine) newPlaybackEventDeactivator(p *playbackEvent) func() {
 	return func() {
 		delete(e.activePlaybackEvents, p)
+		p.notify(DoneNotification)
+		// stop analyzing its spectrum
+		if a := p.currentSpectrumAnalyzer(); a != nil {
+			a.close()
+		}
+		// streaming tables have a disk reader to stop
+		if p.stream != nil {
+			p.stream.stop()
+		}
 	}
 }
 
@@ -423,18 +618,55 @@ func (e *Engine) Play(playbackEvents ...*playbackEvent) {
 
 	// add the events to the internal active event "set"
 	for _, playbackEvent := range playbackEvents {
-		// queue the playback event (shouldn't block, because the
-		// channel is buffered with a large (magic) number unlikely
-		// to be surpassed for audio applications...)
-		e.newPlaybackEvents <- playbackEvent
+		// events prepared before the stream was reconfigured (at
+		// another sample rate) are rebased to the current one
+		if playbackEvent.sampleRate != e.streamSampleRate {
+			playbackEvent.setSampleRate(e.streamSampleRate)
+		}
+		// streaming tables begin reading ahead from disk
+		if playbackEvent.stream != nil {
+			playbackEvent.stream.start()
+		}
+		// and spectrum analysis begins
+		playbackEvent.isPlayed = true
+		if a := playbackEvent.currentSpectrumAnalyzer(); a != nil {
+			a.start()
+		}
+		// queue the playback event (the channel is buffered with a
+		// large (magic) number unlikely to be surpassed for audio
+		// applications...) and should it be full, drop the event
+		// rather than block (see Stats())
+		select {
+		case e.newPlaybackEvents <- playbackEvent:
+		default:
+			atomic.AddInt64(&e.stats.droppedEvents, 1)
+			if playbackEvent.stream != nil {
+				playbackEvent.stream.stop()
+			}
+			if a := playbackEvent.currentSpectrumAnalyzer(); a != nil {
+				a.close()
+			}
+		}
 	}
 }
 
 // the callback which portaudio uses to fill the output buffer
-// the output buffer is assumed to be interleaved stereo format
-func (e *Engine) streamCallback(in, out []float32) {
+// the output buffer is interleaved, with as many channels as the stream was
+// opened with (see outputs.go)
+// (the time info and status flags are for the statistics, see stats.go)
+func (e *Engine) streamCallback(in, out []float32, timeInfo portaudio.StreamCallbackTimeInfo, flags portaudio.StreamCallbackFlags) {
+
+	var (
+		left, right float64
+		// the channels the stream was opened with (NB. not the stream
+		// parameters, which can change while the stream is running)
+		outputChannels = e.openStreamParameters.Output.Channels
+		inputChannels  = e.openStreamParameters.Input.Channels
+		hasInput       = e.openStreamParameters.Input.Device != nil
+	)
 
-	var left, right float64
+	// time the callback
+	callbackStartTime := time.Now()
 
 	// if there are new playback events recently encountered append
 	// them to the active playback events set
@@ -448,37 +680,89 @@ func (e *Engine) streamCallback(in, out []float32) {
 		e.activePlaybackEvents[<-e.newPlaybackEvents] = true
 	}
 
-	// for each (stereo interleaved) output frame
-	for n := 0; n < len(out); n += 2 {
+	// for each (interleaved) output frame
+	for n := 0; n < len(out); n += outputChannels {
+		frame := out[n : n+outputChannels]
 		// clear the current output frame (to avoid explosive accumulation)
-		out[n] = 0.0
-		out[n+1] = 0.0
+		for c := range frame {
+			frame[c] = 0.0
+		}
 		// for each event in the active playback events
 		for playbackEvent, _ := range e.activePlaybackEvents {
 			// accumulate a frame of audio from the event
-			// into the output buffer's current frame
+			// into its pair of the output buffer's current frame
 			left, right = playbackEvent.tick()
-			out[n] += float32(left)
-			out[n+1] += float32(right)
+			mixFrame(frame, playbackEvent.outputPair, left, right)
 		}
 	}
 
-	// monitor audio input (if not muted and device exists)
-	if e.inputAmplitude != 0 && e.streamParameters.Input.Device != nil {
-		switch e.streamParameters.Input.Channels {
-		case 1:
-			// mono
-			for n := 0; n < len(in); n++ {
-				out[2*n] += in[n] * e.inputAmplitude
-				out[2*n+1] += in[n] * e.inputAmplitude
-			}
-		case 2:
-			// stereo
-			for n := 0; n < len(in); n += 2 {
-				out[n] += in[n] * e.inputAmplitude
-				out[n+1] += in[n+1] * e.inputAmplitude
+	// map the channels of the audio input (if the device exists) to the
+	// (mono or stereo) input source
+	var (
+		input               []float32
+		inputSourceChannels int
+	)
+	if hasInput && inputChannels > 0 {
+		input, inputSourceChannels = e.mapInput(in, inputChannels)
+	}
+
+	// play the input source (if not muted)
+	if e.inputAmplitude != 0 && input != nil {
+		inputAmplitude := float64(e.inputAmplitude)
+		for n := 0; n < len(input)/inputSourceChannels; n++ {
+			// (mono is the same on both sides)
+			left = float64(input[n*inputSourceChannels]) * inputAmplitude
+			right = float64(input[(n+1)*inputSourceChannels-1]) * inputAmplitude
+			left, right = e.input.tick(left, right, inputSourceChannels)
+			mixFrame(out[n*outputChannels:(n+1)*outputChannels], e.input.outputPair, left, right)
+		}
+	}
+
+	// loop (recording the input or the output so far, and playing the
+	// loop), see looper.go
+	e.looper.applyCommands()
+	if atomic.LoadInt32(&e.looper.state) != looperIdle {
+		var inputLeft, inputRight float64
+		for n := 0; n < len(out)/outputChannels; n++ {
+			// (mono is the same on both sides)
+			if input != nil {
+				inputLeft = float64(input[n*inputSourceChannels])
+				inputRight = float64(input[(n+1)*inputSourceChannels-1])
 			}
+			e.looper.tick(out[n*outputChannels:(n+1)*outputChannels], inputLeft, inputRight)
 		}
 	}
 
+	// fade the output (when reconfiguring)
+	e.fade(out, outputChannels)
+
+	// meter the input (raw, before the input gain)
+	for n := 0; n < len(input); n += inputSourceChannels {
+		e.inputMeter.tick(float64(input[n]), float64(input[n+inputSourceChannels-1]))
+	}
+
+	// meter the master output (pair 0)
+	for n := 0; n < len(out); n += outputChannels {
+		e.masterMeter.tick(stereoFrame(out[n : n+outputChannels]))
+	}
+
+	// analyze the spectrum of the output (if analyzing)
+	if a, _ := e.spectrumAnalyzer.Load().(*SpectrumAnalyzer); a != nil {
+		for n := 0; n < len(out); n += outputChannels {
+			a.tick(stereoFrame(out[n : n+outputChannels]))
+		}
+	}
+
+	// sample the input (if sampling)
+	if s, _ := e.sampler.Load().(*sampler); s != nil {
+		s.record(input, inputSourceChannels)
+	}
+
+	// record the output (if recording)
+	if r, _ := e.recorder.Load().(*recorder); r != nil {
+		r.record(out)
+	}
+
+	// update the statistics
+	e.stats.update(time.Since(callbackStartTime), len(out)/outputChannels, len(e.activePlaybackEvents), timeInfo, flags)
 }
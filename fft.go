@@ -0,0 +1,49 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// a (radix-2, in-place, iterative) fast fourier transform
+// re and im are the real and imaginary parts, their length must be a power
+// of 2
+//
+// adapted from the cooley-tukey algorithm described here:
+// https://en.wikipedia.org/wiki/Cooley%E2%80%93Tukey_FFT_algorithm
+func fft(re, im []float64) {
+	n := len(re)
+
+	// bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			re[i], re[j] = re[j], re[i]
+			im[i], im[j] = im[j], im[i]
+		}
+	}
+
+	// butterflies
+	for size := 2; size <= n; size <<= 1 {
+		angle := -2.0 * math.Pi / float64(size)
+		wRe, wIm := math.Cos(angle), math.Sin(angle)
+		for start := 0; start < n; start += size {
+			// twiddle factor
+			tRe, tIm := 1.0, 0.0
+			for k := 0; k < size/2; k++ {
+				a := start + k
+				b := a + size/2
+				xRe := re[b]*tRe - im[b]*tIm
+				xIm := re[b]*tIm + im[b]*tRe
+				re[b] = re[a] - xRe
+				im[b] = im[a] - xIm
+				re[a] += xRe
+				im[a] += xIm
+				tRe, tIm = tRe*wRe-tIm*wIm, tRe*wIm+tIm*wRe
+			}
+		}
+	}
+}
@@ -4,10 +4,28 @@ import (
 	"math"
 )
 
-// a "simple" 12db per octave resonant filter algorithm
+// resonant filters
 //
+// There are a few filter models to choose from:
+// simple: a "simple" 12db per octave resonant filter algorithm
+// svf:    a zero delay feedback state variable filter (see svf.go)
+// ladder: a 4 pole (24db per octave) ladder filter, which self oscillates
+//         at high resonance (see ladder.go)
 //
-// adapted from here:
+// The cutoff is a value from 0 to 1.  For the simple model it's the filter
+// coefficient itself (so the frequency it corresponds to depends on the sample
+// rate), for the svf and ladder it spans 20hz to 20khz exponentially.  The
+// cutoff can also be set in hz (independent of the sample rate) and can
+// follow the note played (key tracking).
+//
+// Some filter modes are the same for every model:
+// comb:    a tuned feedback comb filter (see comb.go), the cutoff is its
+//          tuning, and the resonance its feedback
+// formant: a vowel filter (see formant.go), the cutoff morphs the vowel
+//          (0 => a, 0.25 => e, 0.5 => i, 0.75 => o, 1 => u)
+// allpass: an svf allpass (which only shifts phase) around the cutoff
+//
+// the simple model is adapted from here:
 // http://www.martin-finke.de/blog/articles/audio-plugins-013-filter/
 // which is itself adapted from this:
 // http://www.musicdsp.org/showone.php?id=29
@@ -22,45 +40,160 @@ const (
 	LPFilter
 	HPFilter
 	BPFilter
+	NotchFilter
+	PeakFilter
+	CombFilter
+	FormantFilter
+	AllpassFilter
+)
+
+// filter model enum
+type FilterModel int
+
+const (
+	SimpleFilter FilterModel = iota
+	SVFFilter
+	LadderFilter
+)
+
+const (
+	// the frequencies the cutoff (0 to 1) spans for the svf and ladder
+	filterMinimumCutoffInHz float64 = 20.0
+	filterMaximumCutoffInHz float64 = 20000.0
+	// the cutoff is kept below nyquist (relative to the sample rate)
+	filterMaximumCutoffRatio float64 = 0.49
+	// the largest cutoff coefficient of the simple model
+	// nb, it cannot (ever) equal 1
+	filterMaximumCoefficient float64 = 0.9999999999999999
 )
 
 type filter struct {
-	filterMode FilterMode
+	filterModel FilterModel
+	filterMode  FilterMode
 	// cutoff, q (resonance), and the sample rate of the filter
-	// nb, cutoff cannot (ever) equal 1
 	cutoff, resonance float64
-	// these values are used in the actual IIR filter computation
+	sampleRate        float64
+	// key tracking, how much (0 to 1) the cutoff follows the note played
+	// (relative to note 0), and that note
+	keyTracking, note float64
+	// these values are used in the actual IIR filter computation (of the
+	// simple model).  The coefficient is the cutoff (once key tracked).
+	coefficient            float64
 	feedback               float64
 	buf0, buf1, buf2, buf3 float64
+	// the other models (and modes)
+	svf     svf
+	ladder  ladder
+	comb    comb
+	formant formant
 }
 
-func newFilter() *filter {
+func newFilter(sampleRate float64) *filter {
 
 	f := &filter{
-		filterMode: LPFilter,
-		cutoff:     0.9999999999999999,
-		resonance:  0.0,
+		filterModel: SimpleFilter,
+		filterMode:  LPFilter,
+		cutoff:      filterMaximumCoefficient,
+		resonance:   0.0,
+		sampleRate:  sampleRate,
 	}
 
-	f.calculateFeedback()
+	f.calculateCoefficients()
 
 	return f
 
 }
 
-func (f *filter) calculateFeedback() {
-	f.feedback = math.Min(f.resonance+f.resonance/(1.0-f.cutoff), 1.0)
+// (re)calculate the coefficients of the current model, given the cutoff,
+// resonance, and key tracking
+func (f *filter) calculateCoefficients() {
+	// how much the note played moves the cutoff frequency
+	tracking := math.Pow(2, f.keyTracking*f.note/12.0)
+
+	// the modes which are the same for every model
+	switch f.filterMode {
+	case CombFilter:
+		f.comb.setCoefficients(f.cutoffInHz()*tracking/f.sampleRate, f.resonance)
+		return
+	case FormantFilter:
+		f.formant.setCoefficients(f.cutoff, f.resonance, f.sampleRate)
+		return
+	case AllpassFilter:
+		f.svf.setCoefficients(f.frequency(tracking), f.resonance)
+		return
+	}
+
+	switch f.filterModel {
+	case SVFFilter:
+		f.svf.setCoefficients(f.frequency(tracking), f.resonance)
+	case LadderFilter:
+		f.ladder.setCoefficients(f.frequency(tracking), f.resonance)
+	default: // SimpleFilter
+		f.coefficient = f.cutoff
+		if tracking != 1.0 {
+			f.coefficient = f.cutoffFromHz(f.cutoffInHz() * tracking)
+		}
+		f.feedback = math.Min(f.resonance+f.resonance/(1.0-f.coefficient), 1.0)
+	}
+}
+
+// the (key tracked) cutoff frequency relative to the sample rate
+func (f *filter) frequency(tracking float64) float64 {
+	return math.Min(f.cutoffInHz()*tracking/f.sampleRate, filterMaximumCutoffRatio)
+}
+
+// the cutoff (0 to 1) in hz, for the current model
+func (f *filter) cutoffInHz() float64 {
+	switch f.filterModel {
+	case SVFFilter, LadderFilter:
+		return filterMinimumCutoffInHz * math.Pow(filterMaximumCutoffInHz/filterMinimumCutoffInHz, f.cutoff)
+	default: // SimpleFilter
+		// the frequency of a one pole filter with this coefficient
+		return -math.Log(1.0-f.cutoff) * f.sampleRate / (2.0 * math.Pi)
+	}
+}
+
+// the cutoff (0 to 1) of a frequency in hz, for the current model
+func (f *filter) cutoffFromHz(hz float64) float64 {
+	hz = math.Max(hz, 0.0)
+	switch f.filterModel {
+	case SVFFilter, LadderFilter:
+		if hz <= filterMinimumCutoffInHz {
+			return 0.0
+		}
+		return math.Min(math.Log(hz/filterMinimumCutoffInHz)/math.Log(filterMaximumCutoffInHz/filterMinimumCutoffInHz), filterMaximumCoefficient)
+	default: // SimpleFilter
+		return math.Min(1.0-math.Exp(-2.0*math.Pi*hz/f.sampleRate), filterMaximumCoefficient)
+	}
 }
 
 // filter the input
 func (f *filter) tick(input float64) float64 {
+	switch f.filterMode {
+	case NoFilter:
+		return input
+	case CombFilter:
+		return f.comb.tick(input)
+	case FormantFilter:
+		return f.formant.tick(input)
+	case AllpassFilter:
+		return f.svf.tick(input, AllpassFilter)
+	}
+
+	switch f.filterModel {
+	case SVFFilter:
+		return f.svf.tick(input, f.filterMode)
+	case LadderFilter:
+		return f.ladder.tick(input, f.filterMode)
+	}
+
 	// ordinarily ----------------------------------> (this term    )
 	// is buf0 - buf3, but I chose buf0 - buf2... seemed to ease back
 	// the filter instability a bit
-	f.buf0 += f.cutoff * (input - f.buf0 + f.feedback*(f.buf0-f.buf2))
-	f.buf1 += f.cutoff * (f.buf0 - f.buf1)
-	f.buf2 += f.cutoff * (f.buf1 - f.buf2)
-	f.buf3 += f.cutoff * (f.buf2 - f.buf3)
+	f.buf0 += f.coefficient * (input - f.buf0 + f.feedback*(f.buf0-f.buf2))
+	f.buf1 += f.coefficient * (f.buf0 - f.buf1)
+	f.buf2 += f.coefficient * (f.buf1 - f.buf2)
+	f.buf3 += f.coefficient * (f.buf2 - f.buf3)
 	switch f.filterMode {
 	case LPFilter:
 		return f.buf3
@@ -68,13 +201,70 @@ func (f *filter) tick(input float64) float64 {
 		return input - f.buf3
 	case BPFilter:
 		return f.buf0 - f.buf3
-	default: // NoFilter
+	case NotchFilter:
+		return input - (f.buf0 - f.buf3)
+	case PeakFilter:
+		return input + (f.buf0 - f.buf3)
+	default:
 		return input
 	}
 }
 
 func (f *filter) setMode(filterMode FilterMode) {
+	if filterMode == f.filterMode {
+		return
+	}
+	// the comb's delay line is only allocated when it's needed
+	if filterMode == CombFilter {
+		f.comb.allocate(f.sampleRate)
+	}
 	f.filterMode = filterMode
+	f.calculateCoefficients()
+}
+
+// change the sample rate, keeping the cutoff at the same frequency
+func (f *filter) setSampleRate(sampleRate float64) {
+	if f.sampleRate > 0.0 {
+		f.cutoff = f.rebasedCutoff(f.cutoff, sampleRate)
+	}
+	f.sampleRate = sampleRate
+	// the comb's delay line is sized for the sample rate
+	if f.comb.delayLine != nil {
+		f.comb.delayLine = nil
+		f.comb.allocate(sampleRate)
+		f.comb.reset()
+	}
+	f.calculateCoefficients()
+}
+
+// a cutoff (0 to 1, for the current model and sample rate) as the cutoff of
+// the same frequency at another sample rate
+func (f *filter) rebasedCutoff(cutoff, sampleRate float64) float64 {
+	switch f.filterModel {
+	case SVFFilter, LadderFilter:
+		// these cutoffs don't depend on the sample rate
+		return cutoff
+	default: // SimpleFilter
+		// 1 - e^(-2*pi*hz/sampleRate)
+		return math.Min(1.0-math.Pow(1.0-cutoff, f.sampleRate/sampleRate), filterMaximumCoefficient)
+	}
+}
+
+// change the filter model, keeping the cutoff at the same frequency
+func (f *filter) setModel(filterModel FilterModel) {
+	if filterModel == f.filterModel {
+		return
+	}
+	hz := f.cutoffInHz()
+	f.filterModel = filterModel
+	f.cutoff = f.cutoffFromHz(hz)
+	// start the new model from silence
+	f.buf0, f.buf1, f.buf2, f.buf3 = 0.0, 0.0, 0.0, 0.0
+	f.svf = svf{}
+	f.ladder = ladder{}
+	f.comb.reset()
+	f.formant = formant{}
+	f.calculateCoefficients()
 }
 
 // set the cutoff frequency (as a value from 0.0 < 1.0)
@@ -84,12 +274,31 @@ func (f *filter) setCutoff(cutoff float64) {
 	// 0 <= cutoff < 1
 	// cutoff cannot be 1 as this would create a divide by 0 error for the
 	// feedback computation)
-	f.cutoff = math.Max(math.Min(cutoff, 0.9999999999999999), 0.0)
-	f.calculateFeedback()
+	f.cutoff = math.Max(math.Min(cutoff, filterMaximumCoefficient), 0.0)
+	f.calculateCoefficients()
+}
+
+// set the cutoff frequency in hz
+func (f *filter) setCutoffInHz(hz float64) {
+	f.setCutoff(f.cutoffFromHz(hz))
 }
 
 func (f *filter) setResonance(resonance float64) {
 	// make sure resonance is always 0 to 1
-	f.resonance = math.Max(math.Min(resonance, 0.9999999999999999), 0.0)
-	f.calculateFeedback()
+	f.resonance = math.Max(math.Min(resonance, filterMaximumCoefficient), 0.0)
+	f.calculateCoefficients()
+}
+
+// set how much (0 to 1) the cutoff follows the note played
+func (f *filter) setKeyTracking(keyTracking float64) {
+	f.keyTracking = math.Max(math.Min(keyTracking, 1.0), 0.0)
+	f.calculateCoefficients()
+}
+
+// set the note played (for key tracking)
+func (f *filter) setNote(note float64) {
+	f.note = note
+	if f.keyTracking != 0.0 {
+		f.calculateCoefficients()
+	}
 }
@@ -0,0 +1,87 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// a vowel (formant) filter
+//
+// Vowels are recognized by the (first 3) resonant peaks of the vocal tract,
+// its formants.  This filters the input with 3 parallel bandpass filters
+// (svfs) tuned to the formants of a vowel.  The vowel (0 to 1) morphs
+// through a, e, i, o, u, interpolating the formants in between, and the
+// resonance narrows the bandwidths.
+//
+// the formants (of a bass voice) are taken from the csound manual:
+// http://www.csounds.com/manual/html/MiscFormants.html
+
+const (
+	formantNumberOfVowels   int = 5
+	formantNumberOfFormants int = 3
+)
+
+// the frequencies (hz), amplitudes (db), and bandwidths (hz) of the formants
+// of each vowel (a, e, i, o, u)
+var (
+	formantFrequencies = [formantNumberOfVowels][formantNumberOfFormants]float64{
+		{600, 1040, 2250},
+		{400, 1620, 2400},
+		{250, 1750, 2600},
+		{400, 750, 2400},
+		{350, 600, 2400},
+	}
+	formantAmplitudes = [formantNumberOfVowels][formantNumberOfFormants]float64{
+		{0, -7, -9},
+		{0, -12, -9},
+		{0, -30, -16},
+		{0, -11, -21},
+		{0, -20, -32},
+	}
+	formantBandwidths = [formantNumberOfVowels][formantNumberOfFormants]float64{
+		{60, 70, 110},
+		{40, 80, 100},
+		{60, 90, 100},
+		{40, 80, 100},
+		{40, 80, 100},
+	}
+)
+
+type formant struct {
+	// a bandpass filter (and its amplitude) per formant
+	bands      [formantNumberOfFormants]svf
+	amplitudes [formantNumberOfFormants]float64
+}
+
+// set the coefficients from the vowel (0 to 1), resonance (0 to 1), and the
+// sample rate
+func (f *formant) setCoefficients(vowel, resonance, sampleRate float64) {
+	// which 2 vowels we're between
+	position := math.Max(0.0, math.Min(vowel, 1.0)) * float64(formantNumberOfVowels-1)
+	v := int(position)
+	if v >= formantNumberOfVowels-1 {
+		v = formantNumberOfVowels - 2
+	}
+	x := position - float64(v)
+
+	for n := range f.bands {
+		morph := func(table [formantNumberOfVowels][formantNumberOfFormants]float64) float64 {
+			return table[v][n] + x*(table[v+1][n]-table[v][n])
+		}
+		frequency := math.Min(morph(formantFrequencies)/sampleRate, filterMaximumCutoffRatio)
+		bandwidth := morph(formantBandwidths) * (1.5 - resonance)
+		// the svf's damping (k = 2 - 2*resonance) should be the
+		// bandwidth relative to the frequency
+		f.bands[n].setCoefficients(frequency, 1.0-0.5*bandwidth/morph(formantFrequencies))
+		f.amplitudes[n] = decibelsToAmplitude(morph(formantAmplitudes))
+	}
+}
+
+// filter the input
+func (f *formant) tick(input float64) float64 {
+	output := 0.0
+	for n := range f.bands {
+		// the bandpass of an svf peaks at 1/k, normalize it to 1
+		output += f.amplitudes[n] * f.bands[n].k * f.bands[n].tick(input, BPFilter)
+	}
+	return output
+}
@@ -26,3 +26,13 @@ func decibelsToAmplitude(db float64) float64 {
 	}
 	return amplitude
 }
+
+// convert amplitude to audio db (amplitudes at or below -80db are
+// GainNegativeInfinity)
+func amplitudeToDecibels(amplitude float64) float64 {
+	db := GainNegativeInfinity
+	if amplitude > 0.0 {
+		db = math.Max(20.0*math.Log10(amplitude), GainNegativeInfinity)
+	}
+	return db
+}
@@ -0,0 +1,253 @@
+package stereophonic
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// granular synthesis
+//
+// A granular playback event reads many short (windowed) grains from its table
+// instead of playing through it.  Each grain starts near the grain position
+// (randomly offset by the position jitter), plays for the grain size, and has
+// its own (random) pitch and pan within the pitch and pan spread.  Grains
+// are spawned density times per second.
+//
+// A granular event is otherwise a regular playback event: its lifecycle,
+// gain, filter, and envelopes all work the same.  The playback speed (see
+// SetSpeed() and SetNote()) and direction (see SetReverse()) apply to each
+// grain.
+
+// grain window shapes
+type GrainWindow int
+
+const (
+	GrainHannWindow GrainWindow = iota
+	GrainTriangleWindow
+	GrainGaussianWindow
+	GrainTrapezoidWindow
+)
+
+const (
+	// how many grains can overlap (more are skipped)
+	granularMaximumGrains int = 64
+	// grain defaults
+	defaultGrainSizeInSeconds float64 = 0.1
+	defaultGrainDensity       float64 = 20.0
+)
+
+type grain struct {
+	// fractional frame index into the table
+	position float64
+	// pitch (as a playback rate multiplier)
+	pitch float64
+	// pan multipliers
+	panLeft, panRight float64
+	// how many frames this grain has played, and for how many frames it
+	// plays in total
+	age, length int
+	// the window shape (as it was when the grain spawned)
+	window GrainWindow
+	active bool
+}
+
+type granulator struct {
+	sampleRate float64
+	// where grains start (0 to 1, relative to the start/end of the table)
+	position float64
+	// how far (randomly) grains start from the position (0 to 1)
+	positionJitter float64
+	// grain duration (in frames)
+	grainSize int
+	// how many grains are spawned per second
+	density float64
+	// how far grain pitch (in semitones) and pan (0 to 1) randomly spread
+	pitchSpread, panSpread float64
+	window                 GrainWindow
+	// the grains (preallocated, so the audio thread doesn't allocate)
+	grains [granularMaximumGrains]grain
+	// frames until the next grain is spawned
+	countdown float64
+	// each granulator gets its own random number generator (the audio
+	// thread shouldn't share one)
+	rng *rand.Rand
+}
+
+func newGranulator(sampleRate float64) *granulator {
+	return &granulator{
+		sampleRate:     sampleRate,
+		position:       0.0,
+		positionJitter: 0.0,
+		grainSize:      int(defaultGrainSizeInSeconds * sampleRate),
+		density:        defaultGrainDensity,
+		pitchSpread:    0.0,
+		panSpread:      0.0,
+		window:         GrainHannWindow,
+		rng:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// change the sample rate, keeping the grain size (in seconds) the same
+func (g *granulator) setSampleRate(sampleRate float64) {
+	ratio := sampleRate / g.sampleRate
+	g.sampleRate = sampleRate
+	if grainSize := int(float64(g.grainSize) * ratio); grainSize >= 1 {
+		g.grainSize = grainSize
+	}
+	g.countdown *= ratio
+}
+
+// compute a (stereo) frame of grains
+func (g *granulator) tick(tp *tablePlayer) (float64, float64) {
+	var left, right float64
+
+	// spawn grains at the density
+	g.countdown--
+	if g.countdown <= 0 {
+		g.spawn(tp)
+		g.countdown += g.sampleRate / g.density
+	}
+
+	// sum the grains
+	for n := range g.grains {
+		gr := &g.grains[n]
+		if !gr.active {
+			continue
+		}
+		l, r := tp.readFrameAt(gr.position)
+		w := gr.windowAt(float64(gr.age) / float64(gr.length))
+		left += l * w * gr.panLeft
+		right += r * w * gr.panRight
+		gr.position += tp.phaseIncrement * gr.pitch
+		gr.age++
+		if gr.age >= gr.length {
+			gr.active = false
+		}
+	}
+
+	// keep the level (roughly) independent of how many grains overlap
+	overlap := math.Max(1.0, g.density*float64(g.grainSize)/g.sampleRate)
+	scale := 1.0 / math.Sqrt(overlap)
+
+	return left * scale, right * scale
+}
+
+// spawn a grain (if there's a free one)
+func (g *granulator) spawn(tp *tablePlayer) {
+	for n := range g.grains {
+		gr := &g.grains[n]
+		if gr.active {
+			continue
+		}
+		// position (within start/end) with jitter
+		position := g.position + g.positionJitter*(2.0*g.rng.Float64()-1.0)
+		position = math.Max(0.0, math.Min(position, 1.0))
+		gr.position = float64(tp.start) + position*float64(tp.end-tp.start)
+		// pitch spread (in semitones)
+		gr.pitch = math.Pow(2, g.pitchSpread*(2.0*g.rng.Float64()-1.0)/12.0)
+		// (equal power) pan spread
+		theta := (g.panSpread*(2.0*g.rng.Float64()-1.0) + 1.0) * math.Pi / 4.0
+		gr.panLeft = math.Cos(theta) * math.Sqrt2
+		gr.panRight = math.Sin(theta) * math.Sqrt2
+		gr.age = 0
+		gr.length = g.grainSize
+		gr.window = g.window
+		gr.active = true
+		return
+	}
+}
+
+// the window at x (0 to 1) through the grain
+func (gr *grain) windowAt(x float64) float64 {
+	switch gr.window {
+	case GrainTriangleWindow:
+		return 1.0 - math.Abs(2.0*x-1.0)
+	case GrainGaussianWindow:
+		d := (x - 0.5) / 0.15
+		return math.Exp(-0.5 * d * d)
+	case GrainTrapezoidWindow:
+		// 10% fade in/out
+		return math.Min(1.0, 10.0*math.Min(x, 1.0-x))
+	default: // GrainHannWindow
+		return 0.5 - 0.5*math.Cos(2.0*math.Pi*x)
+	}
+}
+
+// create/prepare a granular playback event, see Prepare()
+// (for the arguments) and the SetGrain*() setters of the event
+func (e *Engine) PrepareGranular(slot int, delayInSeconds, durationInSeconds float64) (*playbackEvent, error) {
+	p, err := e.Prepare(slot, delayInSeconds, durationInSeconds)
+	if err != nil {
+		return nil, err
+	}
+	// (only granular events have a granulator, most events never need one)
+	p.granulator = newGranulator(p.sampleRate)
+	p.isGranular = true
+	return p, nil
+}
+
+// granular setters (these only affect granular playback events, and do
+// nothing otherwise)
+
+// set where grains start (0 to 1, relative to the start/end of the table)
+func (tp *tablePlayer) SetGrainPosition(position float64) {
+	if tp.granulator == nil {
+		return
+	}
+	tp.granulator.position = math.Max(0.0, math.Min(position, 1.0))
+}
+
+// set how far grains (randomly) start from the grain position (0 to 1)
+func (tp *tablePlayer) SetGrainPositionJitter(jitter float64) {
+	if tp.granulator == nil {
+		return
+	}
+	tp.granulator.positionJitter = math.Max(0.0, math.Min(jitter, 1.0))
+}
+
+// set the duration of new grains (only accepts arguments > 0)
+func (tp *tablePlayer) SetGrainSize(grainSizeInSeconds float64) {
+	if tp.granulator == nil {
+		return
+	}
+	if grainSize := int(grainSizeInSeconds * tp.sampleRate); grainSize >= 1 {
+		tp.granulator.grainSize = grainSize
+	}
+}
+
+// set how many grains are spawned per second (only accepts arguments > 0)
+func (tp *tablePlayer) SetGrainDensity(grainsPerSecond float64) {
+	if tp.granulator == nil {
+		return
+	}
+	if grainsPerSecond > 0 {
+		tp.granulator.density = grainsPerSecond
+	}
+}
+
+// set how far (in semitones) the pitch of each grain randomly spreads
+func (tp *tablePlayer) SetGrainPitchSpread(semitones float64) {
+	if tp.granulator == nil {
+		return
+	}
+	tp.granulator.pitchSpread = math.Abs(semitones)
+}
+
+// set how far the pan of each grain randomly spreads
+// 0: centered
+// 1: anywhere from left to right
+func (tp *tablePlayer) SetGrainPanSpread(spread float64) {
+	if tp.granulator == nil {
+		return
+	}
+	tp.granulator.panSpread = math.Max(0.0, math.Min(spread, 1.0))
+}
+
+// set the window shape of new grains (grains already playing keep theirs)
+func (tp *tablePlayer) SetGrainWindow(window GrainWindow) {
+	if tp.granulator == nil {
+		return
+	}
+	tp.granulator.window = window
+}
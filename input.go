@@ -0,0 +1,315 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// the audio input, as a source of the engine
+//
+// Rather than being added straight to the output, the (monitored) input is a
+// source which is processed like a playback event:
+// 1. the channels of the input device it uses are mapped (see
+//    SetInputChannelMap()) to a mono or stereo source
+// 2. the input gain is applied (see SetInputGain())
+// 3. a gate (an envelope follower) silences it when it's quieter than a
+//    threshold (ie. to cut the hum/hiss of a guitar amp between notes)
+// 4. it's filtered (exactly like a playback event's filter)
+// 5. it's balanced and panned (exactly like a playback event's balance/pan)
+// 6. it plays on a pair of output channels (see outputs.go)
+//
+// The input is configured with e.Input(), and (as before) it's muted by
+// setting the input gain to stereophonic.GainNegativeInfinity.
+//
+// NB. there's no effects stage or mixer bus for it to go through, as the
+// engine doesn't have either (everything mixes straight into the output
+// channels).  Should they be added, the input source is where it would be
+// routed to them.
+//
+// ex:
+//  e.SetInputChannels(4)
+//  e.SetInputChannelMap(2)       // <--- the 3rd channel (mono)
+//  input := e.Input()
+//  input.SetGate(-50.0, 0.001, 0.1)
+//  input.SetFilterMode(stereophonic.HPFilter)
+//  input.SetFilterCutoffInHz(80.0)
+//  input.SetPan(-0.3)
+
+const (
+	// gate defaults
+	defaultGateAttackInSeconds  float64 = 0.001
+	defaultGateReleaseInSeconds float64 = 0.1
+)
+
+type InputSource struct {
+	sampleRate float64
+	// which channels of the input device are the source (left, right) and
+	// whether it's stereo.  Unless they're mapped, the source is the first
+	// 1 or 2 channels
+	channelMap    [2]int
+	isStereo      bool
+	hasChannelMap bool
+	// the gate, its threshold (an amplitude), its times (in seconds) and
+	// their one pole smoothing factors, the level of the envelope follower,
+	// and the gain of the gate (0 closed, 1 open)
+	isGating                                  bool
+	gateThreshold                             float64
+	gateAttackInSeconds, gateReleaseInSeconds float64
+	gateAttackFactor, gateReleaseFactor       float64
+	gateEnvelope, gateGain                    float64
+	// filters (1 per channel)
+	filterLeft, filterRight *filter
+	// balance (see tablePlayer.SetBalance())
+	balanceMultiplierLeft, balanceMultiplierRight float64
+	// panning (see pan.go), the matrix is for a source of panChannels
+	isPanning                  bool
+	pan, stereoWidth           float64
+	panLaw                     PanLaw
+	panChannels                int
+	panMatrix, targetPanMatrix [4]float64
+	panSmoothingFactor         float64
+	// which pair of output channels it plays on
+	outputPair int
+}
+
+func newInputSource(sampleRate float64) *InputSource {
+	s := &InputSource{
+		gateAttackInSeconds:    defaultGateAttackInSeconds,
+		gateReleaseInSeconds:   defaultGateReleaseInSeconds,
+		gateGain:               1.0,
+		filterLeft:             newFilter(sampleRate),
+		filterRight:            newFilter(sampleRate),
+		balanceMultiplierLeft:  1.0,
+		balanceMultiplierRight: 1.0,
+		stereoWidth:            1.0,
+		panChannels:            2,
+	}
+	// unfiltered, until a filter mode is set
+	s.filterLeft.setMode(NoFilter)
+	s.filterRight.setMode(NoFilter)
+	s.setSampleRate(sampleRate)
+	return s
+}
+
+// (re)compute everything which depends on the sample rate
+func (s *InputSource) setSampleRate(sampleRate float64) {
+	s.sampleRate = sampleRate
+	s.gateAttackFactor = smoothingFactor(s.gateAttackInSeconds, sampleRate)
+	s.gateReleaseFactor = smoothingFactor(s.gateReleaseInSeconds, sampleRate)
+	s.panSmoothingFactor = smoothingFactor(defaultPanSmoothingInSeconds, sampleRate)
+	s.filterLeft.setSampleRate(sampleRate)
+	s.filterRight.setSampleRate(sampleRate)
+}
+
+// get the input source (to configure its processing)
+func (e *Engine) Input() *InputSource {
+	return e.input
+}
+
+// map channels of the input device (counting from 0) to the input source, 1
+// channel is a mono source, 2 channels (left, right) are a stereo source.  No
+// channels resets it to the first 1 or 2 channels (of however many input
+// channels there are, see SetInputChannels()).
+// ex:
+//  e.SetInputChannelMap(3)    // <--- mono, from the 4th channel
+//  e.SetInputChannelMap(4, 5) // <--- stereo, from the 5th and 6th channels
+func (e *Engine) SetInputChannelMap(channels ...int) error {
+	if !e.initialized {
+		return errorEngineNotInitialized
+	}
+	if e.streamParameters.Input.Device == nil {
+		return errorDeviceDoesNotExist
+	}
+	if len(channels) > 2 {
+		return errorUnsupportedNumberOfChannels
+	}
+	for _, c := range channels {
+		if c < 0 || c >= e.streamParameters.Input.Device.MaxInputChannels {
+			return errorUnsupportedNumberOfChannels
+		}
+	}
+	switch len(channels) {
+	case 0:
+		e.input.hasChannelMap = false
+	case 1:
+		e.input.channelMap = [2]int{channels[0], channels[0]}
+		e.input.isStereo = false
+		e.input.hasChannelMap = true
+	case 2:
+		e.input.channelMap = [2]int{channels[0], channels[1]}
+		e.input.isStereo = true
+		e.input.hasChannelMap = true
+	}
+	return nil
+}
+
+// which channels of the input device (with a number of channels) are the
+// source (left, right), and how many channels the source has
+func (s *InputSource) mappedChannels(deviceChannels int) (int, int, int) {
+	left, right, channels := 0, 1, 2
+	if s.hasChannelMap {
+		left, right = s.channelMap[0], s.channelMap[1]
+		if !s.isStereo {
+			channels = 1
+		}
+	} else if deviceChannels == 1 {
+		right, channels = 0, 1
+	}
+	// channels the device wasn't opened with are its last channel
+	if left >= deviceChannels {
+		left = deviceChannels - 1
+	}
+	if right >= deviceChannels {
+		right = deviceChannels - 1
+	}
+	return left, right, channels
+}
+
+// map the (interleaved) input of the device into the (interleaved, mono or
+// stereo) input of the source, returning it and how many channels it has
+// (called from the audio thread)
+func (e *Engine) mapInput(in []float32, deviceChannels int) ([]float32, int) {
+	left, right, channels := e.input.mappedChannels(deviceChannels)
+
+	// NB. this only allocates when the buffer size grows
+	frames := len(in) / deviceChannels
+	if cap(e.inputBuffer) < frames*channels {
+		e.inputBuffer = make([]float32, frames*channels)
+	}
+	mapped := e.inputBuffer[:frames*channels]
+	for n := 0; n < frames; n++ {
+		frame := in[n*deviceChannels : (n+1)*deviceChannels]
+		if channels == 1 {
+			mapped[n] = frame[left]
+		} else {
+			mapped[2*n], mapped[2*n+1] = frame[left], frame[right]
+		}
+	}
+	return mapped, channels
+}
+
+// process a frame of the input source (of a mono or stereo source)
+func (s *InputSource) tick(left, right float64, channels int) (float64, float64) {
+	// gate
+	if s.isGating {
+		// follow the envelope of the louder channel
+		level := math.Max(math.Abs(left), math.Abs(right))
+		if level > s.gateEnvelope {
+			s.gateEnvelope += (level - s.gateEnvelope) * s.gateAttackFactor
+		} else {
+			s.gateEnvelope += (level - s.gateEnvelope) * s.gateReleaseFactor
+		}
+		// open (at the attack) or close (at the release) the gate
+		if s.gateEnvelope >= s.gateThreshold {
+			s.gateGain += (1.0 - s.gateGain) * s.gateAttackFactor
+		} else {
+			s.gateGain -= s.gateGain * s.gateReleaseFactor
+		}
+		left *= s.gateGain
+		right *= s.gateGain
+	}
+
+	// filter
+	left = s.filterLeft.tick(left)
+	right = s.filterRight.tick(right)
+
+	// balance
+	left *= s.balanceMultiplierLeft
+	right *= s.balanceMultiplierRight
+
+	// pan
+	if s.isPanning {
+		// the matrix depends on whether the source is mono or stereo
+		// (and as the source itself changed, there's no smoothing)
+		if channels != s.panChannels {
+			s.panChannels = channels
+			s.targetPanMatrix = panMatrix(s.panChannels, s.pan, s.stereoWidth, s.panLaw)
+			s.panMatrix = s.targetPanMatrix
+		}
+		for n := range s.panMatrix {
+			s.panMatrix[n] += (s.targetPanMatrix[n] - s.panMatrix[n]) * s.panSmoothingFactor
+		}
+		m := s.panMatrix
+		left, right = m[0]*left+m[1]*right, m[2]*left+m[3]*right
+	}
+
+	return left, right
+}
+
+// set the gate, which silences the input when it's quieter than the threshold
+// (in db), opening over the attack time and closing over the release time (in
+// seconds).  A threshold of stereophonic.GainNegativeInfinity turns the gate
+// off.
+func (s *InputSource) SetGate(thresholdInDecibels, attackTimeInSeconds, releaseTimeInSeconds float64) {
+	s.gateAttackInSeconds = math.Max(attackTimeInSeconds, 0.0)
+	s.gateReleaseInSeconds = math.Max(releaseTimeInSeconds, 0.0)
+	s.gateAttackFactor = smoothingFactor(s.gateAttackInSeconds, s.sampleRate)
+	s.gateReleaseFactor = smoothingFactor(s.gateReleaseInSeconds, s.sampleRate)
+	s.gateThreshold = decibelsToAmplitude(thresholdInDecibels)
+	if s.gateThreshold == 0.0 {
+		s.isGating = false
+		s.gateGain = 1.0
+		return
+	}
+	s.isGating = true
+}
+
+// filter setters (see the tablePlayer's filter setters)
+func (s *InputSource) SetFilterMode(filterMode FilterMode) {
+	s.filterLeft.setMode(filterMode)
+	s.filterRight.setMode(filterMode)
+}
+func (s *InputSource) SetFilterModel(filterModel FilterModel) {
+	s.filterLeft.setModel(filterModel)
+	s.filterRight.setModel(filterModel)
+}
+func (s *InputSource) SetFilterCutoff(cutoff float64) {
+	s.filterLeft.setCutoff(cutoff)
+	s.filterRight.setCutoff(cutoff)
+}
+func (s *InputSource) SetFilterCutoffInHz(hz float64) {
+	s.SetFilterCutoff(s.filterLeft.cutoffFromHz(hz))
+}
+func (s *InputSource) SetFilterResonance(resonance float64) {
+	s.filterLeft.setResonance(resonance)
+	s.filterRight.setResonance(resonance)
+}
+
+// set the balance (-1 left only, 0 both, 1 right only)
+func (s *InputSource) SetBalance(balance float64) {
+	balance = math.Max(-1.0, math.Min(balance, 1.0))
+	s.balanceMultiplierLeft = math.Min(1.0, 1.0-balance)
+	s.balanceMultiplierRight = math.Min(1.0, 1.0+balance)
+}
+
+// panning setters (see pan.go)
+func (s *InputSource) SetPan(pan float64) {
+	s.pan = math.Max(-1.0, math.Min(pan, 1.0))
+	s.startPanning()
+}
+func (s *InputSource) SetPanLaw(panLaw PanLaw) {
+	s.panLaw = panLaw
+	if s.isPanning {
+		s.targetPanMatrix = panMatrix(s.panChannels, s.pan, s.stereoWidth, s.panLaw)
+	}
+}
+func (s *InputSource) SetStereoWidth(width float64) {
+	s.stereoWidth = math.Max(0.0, math.Min(width, maximumStereoWidth))
+	s.startPanning()
+}
+
+// start panning (if it's off) and update the target matrix
+func (s *InputSource) startPanning() {
+	s.targetPanMatrix = panMatrix(s.panChannels, s.pan, s.stereoWidth, s.panLaw)
+	if !s.isPanning {
+		s.panMatrix = s.targetPanMatrix
+		s.isPanning = true
+	}
+}
+
+// set which pair of output channels the input plays on (see outputs.go)
+func (s *InputSource) SetOutputPair(pair int) {
+	if pair < 0 {
+		pair = 0
+	}
+	s.outputPair = pair
+}
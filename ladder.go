@@ -0,0 +1,80 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// a 4 pole (24db per octave) ladder filter, in the style of the moog
+//
+// 4 (zero delay feedback) one pole lowpass stages in series, with the output
+// fed back (inverted) to the input.  The feedback is solved for instantly
+// (no unit delay) so the cutoff and resonance stay accurate, and the input
+// to the ladder is saturated, which bounds the filter once the feedback is
+// high enough to self oscillate (at resonance near 1).
+//
+// The highpass and bandpass responses are mixes of the stages (like the
+// oberheim xpander), the notch and peak are mixed (like the svf's) from
+// the lowpass and highpass.
+//
+// adapted from vadim zavalishin's "the art of va filter design":
+// https://www.native-instruments.com/fileadmin/ni_media/downloads/pdf/VAFilterDesign_2.1.0.pdf
+
+const (
+	// the feedback at full resonance (a linear ladder self oscillates at 4)
+	ladderMaximumFeedback float64 = 4.2
+)
+
+type ladder struct {
+	// the cutoff (prewarped) and the feedback
+	g, k float64
+	// the states of the 4 stages
+	s [4]float64
+}
+
+// set the coefficients from the cutoff frequency (relative to the sample
+// rate) and resonance (0 to 1)
+func (l *ladder) setCoefficients(frequency, resonance float64) {
+	l.g = math.Tan(math.Pi * frequency)
+	l.k = ladderMaximumFeedback * resonance
+}
+
+// filter the input, returning the response of the filter mode
+func (l *ladder) tick(input float64, filterMode FilterMode) float64 {
+	var (
+		// the gain of each stage
+		G = l.g / (1.0 + l.g)
+		// the output of the ladder (without its input)
+		S = (G*G*G*l.s[0] + G*G*l.s[1] + G*l.s[2] + l.s[3]) / (1.0 + l.g)
+		// the input of the ladder (solving the feedback loop)
+		u = math.Tanh((input - l.k*S) / (1.0 + l.k*G*G*G*G))
+		y [4]float64
+	)
+
+	// run the stages
+	x := u
+	for i := range l.s {
+		v := G * (x - l.s[i])
+		y[i] = v + l.s[i]
+		l.s[i] = y[i] + v
+		x = y[i]
+	}
+
+	var (
+		low  = y[3]
+		high = u - 4.0*y[0] + 6.0*y[1] - 4.0*y[2] + y[3]
+	)
+	switch filterMode {
+	case LPFilter:
+		return low
+	case HPFilter:
+		return high
+	case BPFilter:
+		return 4.0*y[1] - 8.0*y[2] + 4.0*y[3]
+	case NotchFilter:
+		return low + high
+	case PeakFilter:
+		return low - high
+	default:
+		return input
+	}
+}
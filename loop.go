@@ -0,0 +1,125 @@
+package stereophonic
+
+// loop modes
+//
+// How playback behaves at the loop points (when looping is on):
+// forward:             the loop wraps from its end to its start
+// backward:            playback bounces at the loop end, then the loop plays
+//                      backwards (wrapping from its start to its end)
+// ping-pong:           playback bounces (reverses direction) at both loop points
+// one-shot-then-loop:  the whole slice (start to end) plays once, and only
+//                      then does the (forward) loop begin
+//
+// All of which are mirrored in reverse playback.
+//
+// A loop can also be a sustain loop (like the loop_sustain mode of SF2/SFZ)
+// which only loops until Release() is called.  Playback then leaves the loop
+// and continues (in the original direction) to play the tail of the slice
+// while the amplitude envelope releases.
+
+type LoopMode int
+
+const (
+	ForwardLoop LoopMode = iota
+	BackwardLoop
+	PingPongLoop
+	OneShotThenLoop
+)
+
+// set how playback behaves at the loop points (see above)
+func (tp *tablePlayer) SetLoopMode(loopMode LoopMode) {
+	tp.loopMode = loopMode
+}
+
+// set whether the loop only loops until Release() (a sustain loop), after
+// which the tail of the slice is played
+func (tp *tablePlayer) SetSustainLoop(isSustainLoop bool) {
+	tp.isSustainLoop = isSustainLoop
+}
+
+// whether the loop is currently looping (a sustain loop stops looping once
+// released)
+func (tp *tablePlayer) isLoopActive() bool {
+	return tp.isLooping && !(tp.isSustainLoop && tp.isSustainReleased)
+}
+
+// whether playback is moving against its direction (as set by SetReverse()),
+// which happens after bouncing off a loop point
+func (tp *tablePlayer) isBounced() bool {
+	return (tp.phaseIncrement < 0.0) != tp.isReversed
+}
+
+// reverse the direction of playback (without altering SetReverse())
+func (tp *tablePlayer) bounce() {
+	tp.phaseIncrement *= -1.0
+	tp.targetPhaseIncrement *= -1.0
+	if tp.stream != nil {
+		tp.stream.setReversed(tp.phaseIncrement < 0.0)
+	}
+}
+
+// whether the loop point playback is heading towards bounces playback back
+// (rather than wrapping around to the other loop point)
+func (tp *tablePlayer) loopBounces() bool {
+	switch tp.loopMode {
+	case PingPongLoop:
+		return true
+	case BackwardLoop:
+		// heading into the loop (in the direction of playback), the
+		// loop end bounces.  Thereafter the loop wraps (backwards).
+		return !tp.isBounced()
+	default:
+		return false
+	}
+}
+
+// whether the loop point playback is heading towards wraps around (and so
+// the loop crossfade applies)
+func (tp *tablePlayer) loopWraps() bool {
+	if tp.loopMode == OneShotThenLoop && !tp.isInLoop {
+		return false
+	}
+	return !tp.loopBounces()
+}
+
+// keep the phase within the loop, given the next frame index
+func (tp *tablePlayer) wrapLoop(next int) {
+	forwardsPlayback := tp.phaseIncrement >= 0.0
+
+	// the first pass of a one-shot-then-loop plays the whole slice, and
+	// only enters the loop at its end
+	if tp.loopMode == OneShotThenLoop && !tp.isInLoop {
+		switch {
+		case forwardsPlayback && next > tp.end:
+			tp.phase = float64(tp.loopStart)
+			tp.isInLoop = true
+			tp.notify(LoopWrappedNotification)
+		case !forwardsPlayback && next < tp.start:
+			tp.phase = float64(tp.loopEnd)
+			tp.isInLoop = true
+			tp.notify(LoopWrappedNotification)
+		}
+		return
+	}
+
+	// out of bounds detection
+	var loopPoint, otherLoopPoint int
+	switch {
+	case forwardsPlayback && tp.loopEnd < next:
+		loopPoint, otherLoopPoint = tp.loopEnd, tp.loopStart
+	case !forwardsPlayback && next < tp.loopStart:
+		loopPoint, otherLoopPoint = tp.loopStart, tp.loopEnd
+	default:
+		return
+	}
+
+	if tp.loopBounces() {
+		// stay at the loop point, heading back the other way
+		tp.phase = float64(loopPoint)
+		tp.bounce()
+	} else {
+		// reset phase to the other loop point
+		tp.phase = float64(otherLoopPoint)
+	}
+	tp.notify(LoopWrappedNotification)
+}
@@ -0,0 +1,740 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// a live looper (like a hardware looper pedal) synced to the tempo
+//
+// The looper records a source (the input, or the master output) into a loop
+// which lasts a whole number of bars of the engine's tempo (see SetTempo()).
+// Once it's recorded, the loop plays back (as a looping playback event, see
+// LoopEvent()) and it can be:
+// - overdubbed, the source is added onto the loop (where it's playing) every
+//   pass, as a new layer, until StopOverdub() is called
+// - multiplied, repeating it to be n times as long (ie. to overdub a longer
+//   phrase over it)
+// - halved, keeping its first half
+// - undone, removing the last layer (or multiplying/halving)
+// - played with the usual tablePlayer setters (reverse, speed, filter, etc.)
+//
+// Recording begins as soon as RecordLoop() is called (so call it on the
+// downbeat).  It records a number of bars, or records until
+// StopLoopRecording(), which quantizes it to the nearest bar.  Past the middle
+// of a bar, it keeps recording to the end of the bar.  Otherwise it's cut at
+// the start of the bar (and playback begins however far into the loop we've
+// recorded past it, so it stays in time).
+//
+// The input source is recorded raw (before its gain and processing) like
+// sampling.  The master output is recorded (from pair 0, see outputs.go)
+// *without* the loop itself, so overdubbing the output doesn't feed the loop
+// back into itself.
+//
+// The looper runs on the audio thread.  Its controls hand it commands (with
+// whatever audio they need, allocated beforehand) through a channel, so it
+// never allocates or blocks.  The controls that edit a loop which is being
+// overdubbed stop the overdub first.
+//
+// ex:
+//  e.SetTempo(120)
+//  e.RecordLoop(stereophonic.LoopInput, 2) // <--- 2 bars
+//  ...
+//  e.OverdubLoop(stereophonic.LoopInput)
+//  ...
+//  e.StopOverdub()
+//  e.UndoLoop()
+//  e.LoopEvent().SetReverse(true)
+
+// the sources the looper records
+type LoopSource int
+
+const (
+	LoopInput LoopSource = iota
+	LoopOutput
+)
+
+const (
+	// the longest a loop recorded until StopLoopRecording() can be
+	maximumLoopInSeconds float64 = 60.0
+	// how many layers can be undone
+	maximumLoopUndoLayers int = 16
+	// how many commands can wait for the audio thread
+	loopCommandQueueSize int = 16
+	// how long we wait (at most) for the audio thread to carry out commands
+	// (and how often we check whether it has)
+	loopCommandTimeout = 500 * time.Millisecond
+	loopPollInterval   = time.Millisecond
+)
+
+var (
+	errorUnsupportedLoopSource error = fmt.Errorf("unsupported loop source")
+	errorNotLoopRecording      error = fmt.Errorf("the looper isn't recording")
+	errorLoopRecording         error = fmt.Errorf("the looper is still recording")
+	errorLoopNotPlaying        error = fmt.Errorf("there's no loop playing")
+	errorNoLoop                error = fmt.Errorf("there's no loop")
+	errorNotOverdubbing        error = fmt.Errorf("the looper isn't overdubbing")
+	errorNothingToUndo         error = fmt.Errorf("there's no loop layer to undo")
+	errorInvalidLoopMultiple   error = fmt.Errorf("invalid loop multiple (it must be at least 2)")
+	errorLoopTooLong           error = fmt.Errorf("the loop is too long to multiply")
+	errorLoopTooShort          error = fmt.Errorf("the loop is too short to halve")
+	errorLooperBusy            error = fmt.Errorf("the looper is busy (too many commands are waiting for the audio thread)")
+	errorLooperTimedOut        error = fmt.Errorf("the looper timed out waiting for the audio thread")
+)
+
+// looper states
+const (
+	looperIdle int32 = iota
+	looperRecording
+	looperPlaying
+)
+
+// looper commands
+type loopCommandType int
+
+const (
+	loopRecordCommand loopCommandType = iota
+	loopStopRecordingCommand
+	loopOverdubCommand
+	loopStopOverdubCommand
+	loopSwapCommand
+	loopStopCommand
+)
+
+// a layer of the loop (its audio, as it was after an overdub or edit).
+// Layers aren't modified once they're replaced, so they can be swapped back
+// in (undone), or shared (halving a loop shares its samples)
+type loopLayer struct {
+	samples  []float64 // interleaved, with room for (at least) nFrames
+	channels int
+	nFrames  int
+}
+
+type loopCommand struct {
+	commandType loopCommandType
+	// the source (recording and overdubbing)
+	source LoopSource
+	// the playback event (recording) and the layer (recording, overdubbing
+	// and swapping)
+	event *playbackEvent
+	layer *loopLayer
+	// how many frames to record (0 records until stopped), and how many
+	// frames are in a bar (recording)
+	nFrames, barInFrames int
+}
+
+type looper struct {
+	// how many commands were sent (by the controls) and applied (by the
+	// audio thread), accessed atomically (and kept first for 64 bit
+	// alignment)
+	sent, applied uint64
+	// the state (idle, recording or playing) and whether it's overdubbing,
+	// written by the audio thread (and accessed atomically)
+	state, isOverdubbing int32
+	// commands waiting for the audio thread
+	commands chan loopCommand
+
+	// these belong to the controls (guarded by the engine's lock): the
+	// current layer (and its sample rate), the layers which can be undone
+	// (oldest first), and the playback event
+	current    *loopLayer
+	sampleRate float64
+	undo       []*loopLayer
+	loopEvent  *playbackEvent
+
+	// these belong to the audio thread: what's recording/playing, how many
+	// frames are recorded (and are to be, 0 until stopped), and how many
+	// frames are in a bar
+	source                          LoopSource
+	event                           *playbackEvent
+	layer                           *loopLayer
+	recordedFrames, recordingFrames int
+	barInFrames                     int
+}
+
+func newLooper() *looper {
+	return &looper{
+		commands: make(chan loopCommand, loopCommandQueueSize),
+	}
+}
+
+// a copy of the layer (to overdub)
+func (layer *loopLayer) copy() *loopLayer {
+	samples := make([]float64, layer.nFrames*layer.channels)
+	copy(samples, layer.samples)
+	return &loopLayer{
+		samples:  samples,
+		channels: layer.channels,
+		nFrames:  layer.nFrames,
+	}
+}
+
+// add a (stereo) frame to frame i of the layer (folded down to mono for a mono
+// layer)
+func (layer *loopLayer) add(i int, left, right float64) {
+	if i < 0 || i >= layer.nFrames {
+		return
+	}
+	if layer.channels == 1 {
+		layer.samples[i] += 0.5 * (left + right)
+		return
+	}
+	layer.samples[2*i] += left
+	layer.samples[2*i+1] += right
+}
+
+// hand a command to the audio thread (without blocking)
+func (l *looper) send(command loopCommand) error {
+	atomic.AddUint64(&l.sent, 1)
+	select {
+	case l.commands <- command:
+		return nil
+	default:
+		atomic.AddUint64(&l.sent, ^uint64(0))
+		return errorLooperBusy
+	}
+}
+
+// wait until the audio thread has applied every command sent, and (if there
+// is one) the condition holds, or the timeout
+func (l *looper) wait(timeout time.Duration, condition func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadUint64(&l.applied) < atomic.LoadUint64(&l.sent) || (condition != nil && !condition()) {
+		if time.Now().After(deadline) {
+			return errorLooperTimedOut
+		}
+		time.Sleep(loopPollInterval)
+	}
+	return nil
+}
+
+// check that a loop is playing, and stop overdubbing it (so its current
+// layer can be read)
+func (l *looper) stopOverdub() error {
+	// (the audio thread catches up first, as a recording or overdub may
+	// still be on its way)
+	if err := l.wait(loopCommandTimeout, nil); err != nil {
+		return err
+	}
+	switch atomic.LoadInt32(&l.state) {
+	case looperIdle:
+		l.forgetIfStopped()
+		return errorLoopNotPlaying
+	case looperRecording:
+		return errorLoopRecording
+	}
+	if atomic.LoadInt32(&l.isOverdubbing) == 0 {
+		return nil
+	}
+	if err := l.send(loopCommand{commandType: loopStopOverdubCommand}); err != nil {
+		return err
+	}
+	return l.wait(loopCommandTimeout, nil)
+}
+
+// once the loop has stopped by itself (its event was released, and the audio
+// thread stopped it) forget its event and the layers to undo, but keep its
+// last layer (to SaveLoop())
+func (l *looper) forgetIfStopped() {
+	if atomic.LoadUint64(&l.applied) < atomic.LoadUint64(&l.sent) {
+		return
+	}
+	if atomic.LoadInt32(&l.state) == looperIdle {
+		l.undo = nil
+		l.loopEvent = nil
+	}
+}
+
+// make a layer current (keeping the previous one to undo)
+func (l *looper) pushLayer(layer *loopLayer) {
+	l.undo = append(l.undo, l.current)
+	if len(l.undo) > maximumLoopUndoLayers {
+		l.undo = l.undo[1:]
+	}
+	l.current = layer
+}
+
+// apply the commands waiting for the audio thread (called from the audio
+// thread)
+func (l *looper) applyCommands() {
+	for {
+		select {
+		case command := <-l.commands:
+			l.apply(command)
+			atomic.AddUint64(&l.applied, 1)
+		default:
+			return
+		}
+	}
+}
+
+// apply a command (called from the audio thread)
+func (l *looper) apply(command loopCommand) {
+	switch command.commandType {
+	case loopRecordCommand:
+		l.source = command.source
+		l.event = command.event
+		l.layer = command.layer
+		l.recordedFrames = 0
+		l.recordingFrames = command.nFrames
+		l.barInFrames = command.barInFrames
+		atomic.StoreInt32(&l.isOverdubbing, 0)
+		atomic.StoreInt32(&l.state, looperRecording)
+	case loopStopRecordingCommand:
+		if atomic.LoadInt32(&l.state) == looperRecording {
+			l.quantize()
+		}
+	case loopOverdubCommand:
+		if atomic.LoadInt32(&l.state) == looperPlaying {
+			l.source = command.source
+			l.swap(command.layer)
+			atomic.StoreInt32(&l.isOverdubbing, 1)
+		}
+	case loopStopOverdubCommand:
+		atomic.StoreInt32(&l.isOverdubbing, 0)
+	case loopSwapCommand:
+		if atomic.LoadInt32(&l.state) == looperPlaying {
+			l.swap(command.layer)
+		}
+	case loopStopCommand:
+		l.stop()
+	}
+}
+
+// quantize the recording to the nearest bar (at least 1, and no longer than
+// the recording can be), then keep recording to the end of the bar or begin
+// playback (called from the audio thread)
+func (l *looper) quantize() {
+	bars := math.Max(math.Round(float64(l.recordedFrames)/float64(l.barInFrames)), 1.0)
+	nFrames := int(bars) * l.barInFrames
+	for nFrames > l.layer.nFrames && nFrames > l.barInFrames {
+		nFrames -= l.barInFrames
+	}
+	l.recordingFrames = nFrames
+	if l.recordedFrames >= nFrames {
+		// we're already past the start of the bar
+		l.play(l.recordedFrames - nFrames)
+	}
+}
+
+// finish recording, and begin playback offset frames into the loop (called
+// from the audio thread)
+func (l *looper) play(offset int) {
+	l.layer.nFrames = l.recordingFrames
+	l.swap(l.layer)
+	tp := l.event.tablePlayer
+	if tp.isReversed {
+		tp.phase = float64(l.layer.nFrames - 1 - offset)
+	} else {
+		tp.phase = float64(offset)
+	}
+	atomic.StoreInt32(&l.state, looperPlaying)
+}
+
+// play a layer, looping the whole of it (and keeping the playback position
+// within it).  Called from the audio thread.
+func (l *looper) swap(layer *loopLayer) {
+	if l.event == nil {
+		return
+	}
+	l.layer = layer
+	l.event.table.samples = layer.samples[:layer.nFrames*layer.channels]
+	l.event.table.nFrames = layer.nFrames
+	tp := l.event.tablePlayer
+	tp.start, tp.end = 0, layer.nFrames-1
+	tp.loopStart, tp.loopEnd = 0, layer.nFrames-1
+	tp.phase = math.Mod(tp.phase, float64(layer.nFrames))
+	if tp.phase < 0 {
+		tp.phase += float64(layer.nFrames)
+	}
+}
+
+// stop (and forget) the loop (called from the audio thread)
+func (l *looper) stop() {
+	l.event = nil
+	l.layer = nil
+	atomic.StoreInt32(&l.isOverdubbing, 0)
+	atomic.StoreInt32(&l.state, looperIdle)
+}
+
+// loop a frame of the output, recording (or overdubbing) the source and
+// mixing in the loop.  Called from the audio thread (for each frame, once
+// everything else is mixed into the frame).
+func (l *looper) tick(frame []float32, inputLeft, inputRight float64) {
+	// the source (the output doesn't include the loop, it's mixed in
+	// afterwards)
+	left, right := inputLeft, inputRight
+	if l.source == LoopOutput {
+		left, right = stereoFrame(frame)
+	}
+
+	switch atomic.LoadInt32(&l.state) {
+	case looperRecording:
+		l.layer.add(l.recordedFrames, left, right)
+		l.recordedFrames++
+		switch {
+		// the recording is as long as it's to be
+		case l.recordingFrames > 0 && l.recordedFrames >= l.recordingFrames:
+			l.play(0)
+		// the recording (until stopped) ran out of room
+		case l.recordingFrames == 0 && l.recordedFrames >= l.layer.nFrames:
+			l.quantize()
+		}
+	case looperPlaying:
+		// NB. the event may finish (and the loop stop) as it ticks
+		event, layer := l.event, l.layer
+		position := int(event.phase)
+		loopLeft, loopRight := event.tick()
+		mixFrame(frame, event.outputPair, loopLeft, loopRight)
+		// overdub where the loop just played (so it's heard next pass)
+		if atomic.LoadInt32(&l.isOverdubbing) == 1 {
+			layer.add(position, left, right)
+		}
+	}
+}
+
+// how many channels a loop source has (the input source's, see input.go, or
+// the stereo output)
+func (e *Engine) loopSourceChannels(source LoopSource) (int, error) {
+	switch source {
+	case LoopInput:
+		if e.openStreamParameters.Input.Device == nil || e.openStreamParameters.Input.Channels < 1 {
+			return 0, errorDeviceDoesNotExist
+		}
+		_, _, channels := e.input.mappedChannels(e.openStreamParameters.Input.Channels)
+		return channels, nil
+	case LoopOutput:
+		return 2, nil
+	default:
+		return 0, errorUnsupportedLoopSource
+	}
+}
+
+// returns a callback which stops the loop once its event is done (ie. it's
+// released), like newPlaybackEventDeactivator()
+func (e *Engine) newLoopDeactivator(p *playbackEvent) func() {
+	return func() {
+		if e.looper.event == p {
+			e.looper.stop()
+		}
+		p.notify(DoneNotification)
+		if a := p.currentSpectrumAnalyzer(); a != nil {
+			a.close()
+		}
+	}
+}
+
+// start recording a new loop of a source (replacing any loop there was).
+// Optionally, the loop is a number of bars long, otherwise it records until
+// StopLoopRecording() (at most about a minute).  Playback begins as soon as
+// recording ends.
+// ex:
+//  e.RecordLoop(stereophonic.LoopInput, 4) // <--- 4 bars of the input
+//  e.RecordLoop(stereophonic.LoopOutput)   // <--- the output, until stopped
+func (e *Engine) RecordLoop(source LoopSource, bars ...int) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if !e.started {
+		return errorEngineNotStarted
+	}
+	channels, err := e.loopSourceChannels(source)
+	if err != nil {
+		return err
+	}
+
+	// how long the recording is (or can be)
+	barInFrames := e.barInFrames(e.streamSampleRate)
+	nFrames := 0
+	maximumFrames := int(maximumLoopInSeconds*e.streamSampleRate) / barInFrames * barInFrames
+	if maximumFrames < barInFrames {
+		maximumFrames = barInFrames
+	}
+	if bars != nil && bars[0] > 0 {
+		nFrames = bars[0] * barInFrames
+		maximumFrames = nFrames
+	}
+	layer := &loopLayer{
+		samples:  make([]float64, maximumFrames*channels),
+		channels: channels,
+		nFrames:  maximumFrames,
+	}
+
+	// the (unlimited duration, looping) playback event of the loop
+	t := &table{
+		name:       "loop",
+		channels:   channels,
+		sampleRate: e.streamSampleRate,
+		samples:    layer.samples,
+		nFrames:    layer.nFrames,
+	}
+	tablePlayer, err := newTablePlayer(t, e.streamSampleRate)
+	if err != nil {
+		return err
+	}
+	p := &playbackEvent{
+		tablePlayer:  tablePlayer,
+		currentState: playbackUnlimitedDuration,
+	}
+	p.SetLooping(true)
+	p.amplitudeADSREnvelope.setDoneAction(e.newLoopDeactivator(p))
+	// (the looper plays it)
+	p.isPlayed = true
+
+	if err := e.looper.send(loopCommand{
+		commandType: loopRecordCommand,
+		source:      source,
+		event:       p,
+		layer:       layer,
+		nFrames:     nFrames,
+		barInFrames: barInFrames,
+	}); err != nil {
+		return err
+	}
+	e.looper.current = layer
+	e.looper.sampleRate = e.streamSampleRate
+	e.looper.undo = nil
+	e.looper.loopEvent = p
+
+	return nil
+}
+
+// stop recording the loop (quantizing it to the nearest bar, see above).  This
+// waits until the loop plays (without holding up the engine meanwhile).
+func (e *Engine) StopLoopRecording() error {
+	e.Lock()
+	l := e.looper
+	if err := l.wait(loopCommandTimeout, nil); err != nil {
+		e.Unlock()
+		return err
+	}
+	if atomic.LoadInt32(&l.state) != looperRecording {
+		e.Unlock()
+		return errorNotLoopRecording
+	}
+	if err := l.send(loopCommand{commandType: loopStopRecordingCommand}); err != nil {
+		e.Unlock()
+		return err
+	}
+	if err := l.wait(loopCommandTimeout, nil); err != nil {
+		e.Unlock()
+		return err
+	}
+	// (it may record up to half a bar more)
+	barInSeconds := float64(e.barInFrames(e.streamSampleRate)) / e.streamSampleRate
+	// NB. unlock before waiting that long, as Play() (and everything else)
+	// locks the engine
+	e.Unlock()
+
+	deadline := time.Now().Add(loopCommandTimeout + time.Duration(barInSeconds*float64(time.Second)))
+	for atomic.LoadInt32(&l.state) == looperRecording {
+		if time.Now().After(deadline) {
+			return errorLooperTimedOut
+		}
+		time.Sleep(loopPollInterval)
+	}
+	return nil
+}
+
+// whether the looper is recording (a loop of a number of bars plays by itself
+// once this returns false)
+func (e *Engine) IsLoopRecording() bool {
+	return atomic.LoadInt32(&e.looper.state) == looperRecording
+}
+
+// the playback event of the loop (nil until a loop is recorded, and once it's
+// stopped), which controls its playback with the usual setters.  Releasing it
+// stops the loop (which can still be saved with SaveLoop()).
+// NB. the looper plays it, don't Play() it
+// ex:
+//  e.LoopEvent().SetReverse(true)
+//  e.LoopEvent().SetSpeed(0.5)
+//  e.LoopEvent().SetFilterCutoff(0.2)
+func (e *Engine) LoopEvent() *playbackEvent {
+	e.Lock()
+	defer e.Unlock()
+
+	e.looper.forgetIfStopped()
+	return e.looper.loopEvent
+}
+
+// start overdubbing a source onto the (playing) loop, as a new layer.  If it's
+// already overdubbing, this begins another layer.
+func (e *Engine) OverdubLoop(source LoopSource) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if _, err := e.loopSourceChannels(source); err != nil {
+		return err
+	}
+	l := e.looper
+	if err := l.stopOverdub(); err != nil {
+		return err
+	}
+	layer := l.current.copy()
+	if err := l.send(loopCommand{
+		commandType: loopOverdubCommand,
+		source:      source,
+		layer:       layer,
+	}); err != nil {
+		return err
+	}
+	l.pushLayer(layer)
+	return nil
+}
+
+// stop overdubbing the loop (keeping the layer)
+func (e *Engine) StopOverdub() error {
+	e.Lock()
+	defer e.Unlock()
+
+	l := e.looper
+	if err := l.wait(loopCommandTimeout, nil); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&l.isOverdubbing) == 0 {
+		return errorNotOverdubbing
+	}
+	return l.stopOverdub()
+}
+
+// whether the looper is overdubbing
+func (e *Engine) IsOverdubbing() bool {
+	return atomic.LoadInt32(&e.looper.isOverdubbing) == 1
+}
+
+// undo the last layer (overdub, multiplying or halving) of the loop
+func (e *Engine) UndoLoop() error {
+	e.Lock()
+	defer e.Unlock()
+
+	l := e.looper
+	if err := l.stopOverdub(); err != nil {
+		return err
+	}
+	if len(l.undo) == 0 {
+		return errorNothingToUndo
+	}
+	layer := l.undo[len(l.undo)-1]
+	if err := l.send(loopCommand{commandType: loopSwapCommand, layer: layer}); err != nil {
+		return err
+	}
+	l.undo = l.undo[:len(l.undo)-1]
+	l.current = layer
+	return nil
+}
+
+// multiply the length of the loop (repeating it) by a number of times, as
+// many as fit in the longest a loop can be (about a minute)
+// ex:
+//  e.MultiplyLoop(2) // <--- a 2 bar loop is now 4 bars (played twice)
+func (e *Engine) MultiplyLoop(times int) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if times < 2 {
+		return errorInvalidLoopMultiple
+	}
+	l := e.looper
+	if err := l.stopOverdub(); err != nil {
+		return err
+	}
+	if fit := int(maximumLoopInSeconds*l.sampleRate) / l.current.nFrames; times > fit {
+		times = fit
+	}
+	if times < 2 {
+		return errorLoopTooLong
+	}
+	n := l.current.nFrames * l.current.channels
+	layer := &loopLayer{
+		samples:  make([]float64, n*times),
+		channels: l.current.channels,
+		nFrames:  l.current.nFrames * times,
+	}
+	for i := 0; i < times; i++ {
+		copy(layer.samples[i*n:(i+1)*n], l.current.samples[:n])
+	}
+	if err := l.send(loopCommand{commandType: loopSwapCommand, layer: layer}); err != nil {
+		return err
+	}
+	l.pushLayer(layer)
+	return nil
+}
+
+// halve the length of the loop (keeping its first half)
+func (e *Engine) HalveLoop() error {
+	e.Lock()
+	defer e.Unlock()
+
+	l := e.looper
+	if err := l.stopOverdub(); err != nil {
+		return err
+	}
+	if l.current.nFrames < 2 {
+		return errorLoopTooShort
+	}
+	// (the samples are shared, as layers aren't modified)
+	layer := &loopLayer{
+		samples:  l.current.samples,
+		channels: l.current.channels,
+		nFrames:  l.current.nFrames / 2,
+	}
+	if err := l.send(loopCommand{commandType: loopSwapCommand, layer: layer}); err != nil {
+		return err
+	}
+	l.pushLayer(layer)
+	return nil
+}
+
+// stop (and forget) the loop, immediately.  To fade it out instead, Release()
+// its LoopEvent().
+func (e *Engine) StopLoop() error {
+	e.Lock()
+	defer e.Unlock()
+
+	l := e.looper
+	if err := l.send(loopCommand{commandType: loopStopCommand}); err != nil {
+		return err
+	}
+	l.current = nil
+	l.undo = nil
+	l.loopEvent = nil
+	return nil
+}
+
+// copy the loop (as it currently is, or was when it stopped) into a slot, to
+// Prepare() events of it like any other table
+func (e *Engine) SaveLoop(slot int) error {
+	e.Lock()
+	defer e.Unlock()
+
+	l := e.looper
+	if err := l.wait(loopCommandTimeout, nil); err != nil {
+		return err
+	}
+	switch atomic.LoadInt32(&l.state) {
+	case looperRecording:
+		return errorLoopRecording
+	case looperPlaying:
+		// (unless it's just stopped by itself)
+		if err := l.stopOverdub(); err != nil && err != errorLoopNotPlaying {
+			return err
+		}
+	}
+	if l.current == nil {
+		return errorNoLoop
+	}
+	samples := make([]float64, l.current.nFrames*l.current.channels)
+	copy(samples, l.current.samples)
+	e.tables[slot] = &table{
+		name:       fmt.Sprintf("loop-%d", slot),
+		channels:   l.current.channels,
+		sampleRate: l.sampleRate,
+		samples:    samples,
+		nFrames:    l.current.nFrames,
+	}
+	delete(e.originalTables, slot)
+	return nil
+}
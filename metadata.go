@@ -0,0 +1,365 @@
+package stereophonic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+// sampler metadata of sound files
+//
+// Sampled instruments often store their loop points and root key (the note
+// they were sampled at) inside the sound file.  In WAV files that's the
+// "smpl" chunk (loops, root key, fine tune), "cue " chunk (markers), and
+// "LIST" "adtl" chunk (marker names).  In AIFF files that's the "MARK" chunk
+// (markers) and "INST" chunk (root key, detune, and loops between markers).
+//
+// libsndfile doesn't give us (all) of these, so we read the chunks ourselves.
+// Metadata is optional, whatever can't be read is just left out.
+//
+// the (wav) chunk formats are described here:
+// http://www.piclist.com/techref/io/serial/midi/wave.html
+// and the aiff chunk formats here:
+// http://paulbourke.net/dataformats/audio/AIFF.html
+
+// the type of a sample loop
+type SampleLoopType int
+
+const (
+	ForwardSampleLoop SampleLoopType = iota
+	AlternatingSampleLoop
+	BackwardSampleLoop
+)
+
+// a loop stored in a sound file (start and end are inclusive frame indices)
+type SampleLoop struct {
+	Start, End int
+	Type       SampleLoopType
+}
+
+// a marker (cue point) stored in a sound file
+type SampleMarker struct {
+	Position int
+	Name     string
+}
+
+// the sampler metadata of a sound file
+type SampleMetadata struct {
+	// the (midi) note the sound file was sampled at, and how many cents
+	// sharp it is (only meaningful if HasRootNote)
+	RootNote    int
+	FineTune    float64
+	HasRootNote bool
+	Loops       []SampleLoop
+	Markers     []SampleMarker
+}
+
+// read the sampler metadata of a (wav or aiff) sound file
+func readSampleMetadata(soundFileName string) SampleMetadata {
+	f, err := os.Open(soundFileName)
+	if err != nil {
+		return SampleMetadata{}
+	}
+	defer f.Close()
+
+	var header [12]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return SampleMetadata{}
+	}
+	switch {
+	case string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return readWAVMetadata(f)
+	case string(header[0:4]) == "FORM" && (string(header[8:12]) == "AIFF" || string(header[8:12]) == "AIFC"):
+		return readAIFFMetadata(f)
+	default:
+		return SampleMetadata{}
+	}
+}
+
+// read the chunks (following the file header) of a riff/iff file, calling
+// found for each chunk whose id is wanted
+func readChunks(r io.ReadSeeker, order binary.ByteOrder, wanted map[string]bool, found func(id string, data []byte)) {
+	// find where the file ends (the chunk sizes are only trusted as far as
+	// that, a broken file could claim a chunk of up to 4GB)
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+	position := start
+
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		position += int64(len(header))
+		id := string(header[0:4])
+		size := int64(order.Uint32(header[4:8]))
+		// stop at a chunk that claims more than is left of the file
+		if size > end-position {
+			return
+		}
+		// chunks are padded to an even size
+		padded := size + size%2
+		position += padded
+		if wanted[id] {
+			data := make([]byte, padded)
+			n, _ := io.ReadFull(r, data)
+			if int64(n) < size {
+				return
+			}
+			found(id, data[:size])
+		} else if _, err := r.Seek(padded, io.SeekCurrent); err != nil {
+			return
+		}
+	}
+}
+
+func readWAVMetadata(r io.ReadSeeker) SampleMetadata {
+
+	var (
+		metadata SampleMetadata
+		// cue point ids -> marker index (to attach names)
+		cues = map[uint32]int{}
+		// cue point ids -> names (the labels may come before the cues)
+		labels = map[uint32]string{}
+	)
+
+	wanted := map[string]bool{"smpl": true, "cue ": true, "LIST": true}
+	readChunks(r, binary.LittleEndian, wanted, func(id string, data []byte) {
+		le := binary.LittleEndian
+		switch id {
+		case "smpl":
+			if len(data) < 36 {
+				return
+			}
+			metadata.RootNote = int(le.Uint32(data[12:16]))
+			// the pitch fraction is a fraction of a semitone
+			metadata.FineTune = 100.0 * float64(le.Uint32(data[16:20])) / 4294967296.0
+			metadata.HasRootNote = true
+			nLoops := int(le.Uint32(data[28:32]))
+			for n := 0; n < nLoops; n++ {
+				if len(data) < 36+24*(n+1) {
+					break
+				}
+				loop := data[36+24*n:]
+				loopType := ForwardSampleLoop
+				switch le.Uint32(loop[4:8]) {
+				case 1:
+					loopType = AlternatingSampleLoop
+				case 2:
+					loopType = BackwardSampleLoop
+				}
+				metadata.Loops = append(metadata.Loops, SampleLoop{
+					Start: int(le.Uint32(loop[8:12])),
+					End:   int(le.Uint32(loop[12:16])),
+					Type:  loopType,
+				})
+			}
+		case "cue ":
+			if len(data) < 4 {
+				return
+			}
+			nCues := int(le.Uint32(data[0:4]))
+			for n := 0; n < nCues; n++ {
+				if len(data) < 4+24*(n+1) {
+					break
+				}
+				cue := data[4+24*n:]
+				cues[le.Uint32(cue[0:4])] = len(metadata.Markers)
+				metadata.Markers = append(metadata.Markers, SampleMarker{
+					Position: int(le.Uint32(cue[20:24])),
+				})
+			}
+		case "LIST":
+			if len(data) < 4 || string(data[0:4]) != "adtl" {
+				return
+			}
+			// the "labl" sub chunks name the cue points
+			readChunks(bytes.NewReader(data[4:]), le, map[string]bool{"labl": true}, func(id string, label []byte) {
+				if len(label) < 4 {
+					return
+				}
+				labels[le.Uint32(label[0:4])] = string(bytes.TrimRight(label[4:], "\x00"))
+			})
+		}
+	})
+
+	for cueID, name := range labels {
+		if m, exists := cues[cueID]; exists {
+			metadata.Markers[m].Name = name
+		}
+	}
+
+	return metadata
+}
+
+func readAIFFMetadata(r io.ReadSeeker) SampleMetadata {
+
+	var (
+		metadata SampleMetadata
+		// marker ids -> marker index (loops refer to markers by id)
+		markers = map[int16]int{}
+		// the loops are resolved once all markers are known
+		inst []byte
+	)
+
+	wanted := map[string]bool{"MARK": true, "INST": true}
+	readChunks(r, binary.BigEndian, wanted, func(id string, data []byte) {
+		be := binary.BigEndian
+		switch id {
+		case "MARK":
+			if len(data) < 2 {
+				return
+			}
+			nMarkers := int(be.Uint16(data[0:2]))
+			data = data[2:]
+			for n := 0; n < nMarkers && len(data) >= 7; n++ {
+				// id, position, then a pascal string (padded to
+				// an even length, including its count byte)
+				markerID := int16(be.Uint16(data[0:2]))
+				position := int(be.Uint32(data[2:6]))
+				count := int(data[6])
+				if len(data) < 7+count {
+					break
+				}
+				markers[markerID] = len(metadata.Markers)
+				metadata.Markers = append(metadata.Markers, SampleMarker{
+					Position: position,
+					Name:     string(data[7 : 7+count]),
+				})
+				next := 7 + count
+				next += next % 2
+				if next > len(data) {
+					break
+				}
+				data = data[next:]
+			}
+		case "INST":
+			if len(data) < 20 {
+				return
+			}
+			metadata.RootNote = int(int8(data[0]))
+			metadata.FineTune = float64(int8(data[1]))
+			metadata.HasRootNote = true
+			inst = data
+		}
+	})
+
+	// the sustain loop, then the release loop
+	if inst != nil {
+		be := binary.BigEndian
+		for _, loop := range [][]byte{inst[8:14], inst[14:20]} {
+			var loopType SampleLoopType
+			switch be.Uint16(loop[0:2]) {
+			case 1:
+				loopType = ForwardSampleLoop
+			case 2:
+				loopType = AlternatingSampleLoop
+			default:
+				// no loop
+				continue
+			}
+			begin, beginExists := markers[int16(be.Uint16(loop[2:4]))]
+			end, endExists := markers[int16(be.Uint16(loop[4:6]))]
+			if !beginExists || !endExists {
+				continue
+			}
+			// NB. markers sit *between* frames, so the loop ends on
+			// the frame before the end marker
+			metadata.Loops = append(metadata.Loops, SampleLoop{
+				Start: metadata.Markers[begin].Position,
+				End:   metadata.Markers[end].Position - 1,
+				Type:  loopType,
+			})
+		}
+	}
+
+	return metadata
+}
+
+// scale the positions of the metadata (for a resampled table)
+func (m SampleMetadata) scaled(factor float64) SampleMetadata {
+	scaled := m
+	scaled.Loops = make([]SampleLoop, len(m.Loops))
+	for n, loop := range m.Loops {
+		scaled.Loops[n] = SampleLoop{
+			Start: int(math.Round(float64(loop.Start) * factor)),
+			End:   int(math.Round(float64(loop.End) * factor)),
+			Type:  loop.Type,
+		}
+	}
+	scaled.Markers = make([]SampleMarker, len(m.Markers))
+	for n, marker := range m.Markers {
+		scaled.Markers[n] = SampleMarker{
+			Position: int(math.Round(float64(marker.Position) * factor)),
+			Name:     marker.Name,
+		}
+	}
+	return scaled
+}
+
+// gets the sampler metadata (loops, markers, root note) of the sound file
+// loaded in a slot
+func (e *Engine) SampleMetadata(slot int) (SampleMetadata, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	table, exists := e.tables[slot]
+	if !exists {
+		return SampleMetadata{}, errorTableDoesNotExist
+	}
+	return table.Metadata(), nil
+}
+
+// set whether new playback events start with the metadata of their sound file
+// applied (see tablePlayer.ApplySampleMetadata())
+func (e *Engine) SetApplySampleMetadata(applySampleMetadata bool) {
+	e.applySampleMetadata = applySampleMetadata
+}
+
+// apply the metadata of the table to playback:
+// its first loop becomes the loop slice (looping is turned on, with the loop
+// mode of its type), and
+// (given a root note) it's tuned so SetNote(0) plays middle c (midi note 60)
+func (tp *tablePlayer) ApplySampleMetadata() {
+	metadata := tp.table.metadata
+
+	if len(metadata.Loops) > 0 {
+		loop := metadata.Loops[0]
+		start := clampInt(loop.Start, 0, tp.table.nFrames-1)
+		end := clampInt(loop.End, 0, tp.table.nFrames-1)
+		if start < end {
+			tp.loopStart = start
+			tp.loopEnd = end
+			tp.SetLooping(true)
+			switch loop.Type {
+			case AlternatingSampleLoop:
+				tp.SetLoopMode(PingPongLoop)
+			case BackwardSampleLoop:
+				tp.SetLoopMode(BackwardLoop)
+			default:
+				tp.SetLoopMode(ForwardLoop)
+			}
+			tp.updateStreamCues()
+		}
+	}
+
+	if metadata.HasRootNote {
+		semitones := float64(60-metadata.RootNote) - metadata.FineTune/100.0
+		tuning := math.Pow(2, semitones/12.0)
+		// retune the current (and target) speed
+		tp.phaseIncrement *= tuning / tp.tuning
+		tp.targetPhaseIncrement *= tuning / tp.tuning
+		tp.tuning = tuning
+	}
+}
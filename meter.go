@@ -0,0 +1,360 @@
+package stereophonic
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// level metering
+//
+// A meter measures the level of a (stereo) signal as it plays:
+// peak:      the loudest sample, held for a while then decaying (dropping at
+//            a rate in db per second) like the peak meter of a mixing desk
+// true peak: the loudest point *between* the samples (which is what a DAC
+//            reconstructs) estimated (within about half a db) by 4x
+//            oversampling.  It can be louder than the sample peak, and it's
+//            what distorts in a DAC (or a lossy encoder)
+// rms:       the average power (smoothed over a time constant) which is
+//            closer to how loud it sounds
+//
+// It also flags clipping (any sample over 0db) which stays flagged until the
+// meter is reset.
+//
+// The engine meters its master output and its input (the raw input, before
+// the input gain).  Playback events are only metered if SetMetering(true) is
+// called on them.  The levels are measured on the audio thread and published
+// (atomically) every so often, so they can be read from anywhere (ie. a UI)
+// without locking, or disturbing playback.
+//
+// Levels are in db, with silence being GainNegativeInfinity.
+//
+// ex:
+//  levels := e.MasterLevels()
+//  if levels.IsClipped { ... }
+//  fmt.Println(levels.PeakLeft, levels.RMSLeft)
+
+const (
+	// default ballistics
+	defaultMeterHoldInSeconds         float64 = 1.5
+	defaultMeterDecayInDecibelsPerSec float64 = 20.0
+	defaultMeterRMSTimeInSeconds      float64 = 0.3
+	// how often (in frames) the levels are published
+	meterPublishIntervalInFrames int = 64
+	// true peak oversampling (and the taps of each phase of its
+	// interpolation filter)
+	truePeakOversampling int = 4
+	truePeakTaps         int = 12
+)
+
+var (
+	// the polyphase interpolation filter of the true peak (windowed sinc)
+	truePeakCoefficients [truePeakOversampling][truePeakTaps]float64 = calculateTruePeakCoefficients()
+	// below this (-80db, see GainNegativeInfinity) levels are silence
+	meterFloor float64 = 0.0001
+)
+
+// the levels of a meter (in db)
+type MeterReading struct {
+	PeakLeft, PeakRight         float64
+	TruePeakLeft, TruePeakRight float64
+	RMSLeft, RMSRight           float64
+	// whether it's gone over 0db since the meter was reset
+	IsClipped bool
+}
+
+// the state of 1 channel of a meter
+type meterChannel struct {
+	peak, truePeak         float64
+	peakHold, truePeakHold int
+	meanSquare             float64
+	history                [truePeakTaps]float64
+	historyIndex           int
+}
+
+// the ballistics of a meter (in seconds, db/s) and their per frame
+// equivalents
+type meterBallistics struct {
+	holdInSeconds, decayInDecibelsPerSecond, rmsTimeInSeconds float64
+	holdInFrames                                              int
+	decayMultiplier, rmsFactor                                float64
+}
+
+type meter struct {
+	// the published levels (float64 bits) stored/loaded atomically (and
+	// kept first for 64 bit alignment) peak l/r, true peak l/r, rms l/r
+	levels [6]uint64
+	// ballistics (only touched by the audio thread, once it's metering)
+	meterBallistics
+	// new *meterBallistics (set by setBallistics()) for the audio thread
+	// to take up on its next tick, and the flag that there are some
+	pendingBallistics    atomic.Value
+	hasPendingBallistics int32
+	// per channel state (only touched by the audio thread)
+	channels [2]meterChannel
+	// frames until the levels are published again
+	framesUntilPublish int
+	// clipping flag (int32 for atomic access)
+	isClipped int32
+	// flag (set by reset()) for the audio thread to clear its state
+	shouldReset int32
+}
+
+// create a meter (with the default ballistics)
+func newMeter(sampleRate float64) *meter {
+	m := &meter{
+		meterBallistics: meterBallistics{
+			holdInSeconds:            defaultMeterHoldInSeconds,
+			decayInDecibelsPerSecond: defaultMeterDecayInDecibelsPerSec,
+			rmsTimeInSeconds:         defaultMeterRMSTimeInSeconds,
+		},
+	}
+	m.meterBallistics.setSampleRate(sampleRate)
+	m.publish()
+	return m
+}
+
+// compute the windowed sinc coefficients of each phase of the true peak
+// interpolator.  Phase p interpolates halfway along the history (between
+// samples) at an offset of p/4, phase 0 being the sample itself.
+func calculateTruePeakCoefficients() [truePeakOversampling][truePeakTaps]float64 {
+	var (
+		coefficients [truePeakOversampling][truePeakTaps]float64
+		center       = float64(truePeakTaps / 2)
+		width        = center + 0.5
+	)
+	for p := 0; p < truePeakOversampling; p++ {
+		for i := 0; i < truePeakTaps; i++ {
+			t := float64(i) - center + float64(p)/float64(truePeakOversampling)
+			sinc := 1.0
+			if t != 0.0 {
+				sinc = math.Sin(math.Pi*t) / (math.Pi * t)
+			}
+			// hann window
+			window := 0.5 * (1.0 + math.Cos(math.Pi*t/width))
+			coefficients[p][i] = sinc * window
+		}
+	}
+	return coefficients
+}
+
+// (re)compute the per frame ballistics for a sample rate
+func (b *meterBallistics) setSampleRate(sampleRate float64) {
+	b.holdInFrames = int(b.holdInSeconds * sampleRate)
+	b.decayMultiplier = 0.0
+	if sampleRate > 0.0 {
+		b.decayMultiplier = math.Pow(10.0, -b.decayInDecibelsPerSecond/(20.0*sampleRate))
+	}
+	b.rmsFactor = smoothingFactor(b.rmsTimeInSeconds, sampleRate)
+}
+
+// change the sample rate of the meter (NB. only while the audio thread isn't
+// metering with it)
+func (m *meter) setSampleRate(sampleRate float64) {
+	m.takePendingBallistics()
+	m.meterBallistics.setSampleRate(sampleRate)
+}
+
+// set the ballistics, which the audio thread takes up on its next tick (so
+// this is safe to call while it's metering)
+func (m *meter) setBallistics(holdInSeconds, decayInDecibelsPerSecond, rmsTimeInSeconds, sampleRate float64) {
+	b := &meterBallistics{
+		holdInSeconds:            math.Max(holdInSeconds, 0.0),
+		decayInDecibelsPerSecond: math.Max(decayInDecibelsPerSecond, 0.0),
+		rmsTimeInSeconds:         math.Max(rmsTimeInSeconds, 0.0),
+	}
+	b.setSampleRate(sampleRate)
+	m.pendingBallistics.Store(b)
+	atomic.StoreInt32(&m.hasPendingBallistics, 1)
+}
+
+// take up the ballistics set by setBallistics() (if there are any)
+func (m *meter) takePendingBallistics() {
+	if atomic.LoadInt32(&m.hasPendingBallistics) == 0 {
+		return
+	}
+	atomic.StoreInt32(&m.hasPendingBallistics, 0)
+	if b, _ := m.pendingBallistics.Load().(*meterBallistics); b != nil {
+		m.meterBallistics = *b
+	}
+}
+
+// measure a sample of a channel
+func (m *meter) tickChannel(c *meterChannel, x float64) {
+	// peak
+	a := math.Abs(x)
+	if a >= c.peak {
+		c.peak, c.peakHold = a, m.holdInFrames
+	} else if c.peakHold > 0 {
+		c.peakHold--
+	} else if c.peak *= m.decayMultiplier; c.peak < meterFloor {
+		c.peak = 0.0
+	}
+
+	// true peak, the loudest of the interpolated points (the history is a
+	// ring, the newest sample being at historyIndex)
+	c.history[c.historyIndex] = x
+	truePeak := 0.0
+	for p := 0; p < truePeakOversampling; p++ {
+		y, j := 0.0, c.historyIndex
+		for i := 0; i < truePeakTaps; i++ {
+			y += c.history[j] * truePeakCoefficients[p][i]
+			if j--; j < 0 {
+				j = truePeakTaps - 1
+			}
+		}
+		truePeak = math.Max(truePeak, math.Abs(y))
+	}
+	if c.historyIndex++; c.historyIndex == truePeakTaps {
+		c.historyIndex = 0
+	}
+	if truePeak >= c.truePeak {
+		c.truePeak, c.truePeakHold = truePeak, m.holdInFrames
+	} else if c.truePeakHold > 0 {
+		c.truePeakHold--
+	} else if c.truePeak *= m.decayMultiplier; c.truePeak < meterFloor {
+		c.truePeak = 0.0
+	}
+
+	// rms
+	if c.meanSquare += (x*x - c.meanSquare) * m.rmsFactor; c.meanSquare < meterFloor*meterFloor {
+		c.meanSquare = 0.0
+	}
+
+	// clipping
+	if a > 1.0 && atomic.LoadInt32(&m.isClipped) == 0 {
+		atomic.StoreInt32(&m.isClipped, 1)
+	}
+}
+
+// measure a frame (called by the audio thread)
+func (m *meter) tick(left, right float64) {
+	if atomic.LoadInt32(&m.shouldReset) != 0 {
+		m.channels = [2]meterChannel{}
+		atomic.StoreInt32(&m.shouldReset, 0)
+	}
+	m.takePendingBallistics()
+	m.tickChannel(&m.channels[0], left)
+	m.tickChannel(&m.channels[1], right)
+	if m.framesUntilPublish--; m.framesUntilPublish <= 0 {
+		m.publish()
+		m.framesUntilPublish = meterPublishIntervalInFrames
+	}
+}
+
+// publish the levels (for reading())
+func (m *meter) publish() {
+	for n := range m.channels {
+		c := &m.channels[n]
+		atomic.StoreUint64(&m.levels[n], math.Float64bits(c.peak))
+		atomic.StoreUint64(&m.levels[2+n], math.Float64bits(c.truePeak))
+		atomic.StoreUint64(&m.levels[4+n], math.Float64bits(math.Sqrt(c.meanSquare)))
+	}
+}
+
+// read the (last published) levels, this is safe to call from anywhere
+func (m *meter) reading() MeterReading {
+	var levels [6]float64
+	for n := range levels {
+		levels[n] = amplitudeToDecibels(math.Float64frombits(atomic.LoadUint64(&m.levels[n])))
+	}
+	return MeterReading{
+		PeakLeft:      levels[0],
+		PeakRight:     levels[1],
+		TruePeakLeft:  levels[2],
+		TruePeakRight: levels[3],
+		RMSLeft:       levels[4],
+		RMSRight:      levels[5],
+		IsClipped:     atomic.LoadInt32(&m.isClipped) != 0,
+	}
+}
+
+// clear the levels and the clipping flag (the audio thread clears its state
+// on its next tick)
+func (m *meter) reset() {
+	atomic.StoreInt32(&m.shouldReset, 1)
+	atomic.StoreInt32(&m.isClipped, 0)
+	for n := range m.levels {
+		atomic.StoreUint64(&m.levels[n], 0)
+	}
+}
+
+// get the levels of the master output
+func (e *Engine) MasterLevels() MeterReading {
+	return e.masterMeter.reading()
+}
+
+// get the levels of the input (before the input gain)
+func (e *Engine) InputLevels() MeterReading {
+	return e.inputMeter.reading()
+}
+
+// clear the master and input levels (and their clipping flags)
+func (e *Engine) ResetMeters() {
+	e.masterMeter.reset()
+	e.inputMeter.reset()
+}
+
+// set the ballistics of the master and input meters, how long (in seconds)
+// peaks are held, how fast (in db per second) they decay after, and the time
+// (in seconds) the rms is averaged over
+func (e *Engine) SetMeterBallistics(holdInSeconds, decayInDecibelsPerSecond, rmsTimeInSeconds float64) {
+	e.Lock()
+	defer e.Unlock()
+	sampleRate := e.streamParameters.SampleRate
+	if e.started {
+		sampleRate = e.streamSampleRate
+	}
+	e.masterMeter.setBallistics(holdInSeconds, decayInDecibelsPerSecond, rmsTimeInSeconds, sampleRate)
+	e.inputMeter.setBallistics(holdInSeconds, decayInDecibelsPerSecond, rmsTimeInSeconds, sampleRate)
+}
+
+// the meter of the playback event (nil unless it's metered)
+func (tp *tablePlayer) currentMeter() *meter {
+	m, _ := tp.meter.Load().(*meter)
+	return m
+}
+
+// set whether the playback event is metered (it isn't by default)
+func (tp *tablePlayer) SetMetering(isMetering bool) {
+	if !isMetering {
+		tp.meter.Store((*meter)(nil))
+		return
+	}
+	if tp.currentMeter() == nil {
+		tp.meter.Store(newMeter(tp.sampleRate))
+	}
+}
+
+// get the levels of the playback event (silence if it isn't metered)
+func (tp *tablePlayer) Levels() MeterReading {
+	if m := tp.currentMeter(); m != nil {
+		return m.reading()
+	}
+	return silentMeterReading()
+}
+
+// clear the levels of the playback event (and its clipping flag)
+func (tp *tablePlayer) ResetMeter() {
+	if m := tp.currentMeter(); m != nil {
+		m.reset()
+	}
+}
+
+// set the ballistics of the playback event's meter (see
+// Engine.SetMeterBallistics())
+func (tp *tablePlayer) SetMeterBallistics(holdInSeconds, decayInDecibelsPerSecond, rmsTimeInSeconds float64) {
+	tp.SetMetering(true)
+	tp.currentMeter().setBallistics(holdInSeconds, decayInDecibelsPerSecond, rmsTimeInSeconds, tp.sampleRate)
+}
+
+// the reading of a silent meter
+func silentMeterReading() MeterReading {
+	return MeterReading{
+		PeakLeft:      GainNegativeInfinity,
+		PeakRight:     GainNegativeInfinity,
+		TruePeakLeft:  GainNegativeInfinity,
+		TruePeakRight: GainNegativeInfinity,
+		RMSLeft:       GainNegativeInfinity,
+		RMSRight:      GainNegativeInfinity,
+	}
+}
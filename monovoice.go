@@ -0,0 +1,284 @@
+package stereophonic
+
+import (
+	"math"
+	"sync"
+)
+
+// a monophonic synth voice
+//
+// A MonoVoice plays 1 note at a time from 1 (unlimited duration) playback
+// event, which lives as long as the voice (it's silent between notes rather
+// than removed from the engine).  Playback settings (filter, envelopes,
+// looping...) are set on the voice as they would be on a playback event.
+//
+// NoteOn()/NoteOff() keep a stack of the held notes, and which one sounds is
+// chosen by the note priority: the last note pressed, the lowest, or the
+// highest.  Releasing a note falls back to the next note (by priority) still
+// held, and releasing every note releases the envelopes.
+//
+// A note pressed while another is held is legato, it doesn't retrigger the
+// envelopes and glides (portamento) from the previous note.  Gliding can also
+// happen between every note (not just legato ones).  The glide time is
+// either constant (however far the notes are) or a rate (seconds per octave).
+//
+// An accented note is louder and has more filter envelope depth (like the
+// tb303).
+//
+// ex:
+//  voice, _ := e.PrepareMonoVoice(slot)
+//  voice.SetLooping(true)
+//  voice.SetGlide(0.05)
+//  voice.NoteOn(0)
+//  voice.NoteOn(7, true) // <--- legato (and accented), glides up from 0
+//  voice.NoteOff(0)
+//  voice.NoteOff(7)      // <--- releases
+
+type NotePriority int
+
+const (
+	LastNotePriority NotePriority = iota
+	LowNotePriority
+	HighNotePriority
+)
+
+type PortamentoMode int
+
+const (
+	ConstantTimePortamento PortamentoMode = iota
+	ConstantRatePortamento
+)
+
+type MonoVoice struct {
+	sync.Mutex
+	// the playback event which the voice plays
+	*playbackEvent
+	// the engine the voice plays in (and whether it's playing yet)
+	engine    *Engine
+	isPlaying bool
+	isClosed  bool
+	// the held notes (in the order they were pressed)
+	heldNotes    []int
+	notePriority NotePriority
+	// the note sounding (or which last sounded), and whether there is one
+	currentNote int
+	hasNote     bool
+	// portamento, the glide time is in seconds (constant time) or seconds
+	// per octave (constant rate)
+	glideTime      float64
+	portamentoMode PortamentoMode
+	isLegatoGlide  bool
+	// the gain (in db) and filter envelope depth, and how much an accent
+	// adds to each
+	gain, filterEnvelopeDepth             float64
+	accentGain, accentFilterEnvelopeDepth float64
+}
+
+// create/prepare a mono voice which plays the sound file in a slot
+func (e *Engine) PrepareMonoVoice(slot int) (*MonoVoice, error) {
+	p, err := e.Prepare(slot, 0.0, 0.0)
+	if err != nil {
+		return nil, err
+	}
+
+	// the voice's event is never removed from the engine (until Close())
+	// so it's silent (its envelopes are off) until a note is played
+	p.amplitudeADSREnvelope.setDoneAction(nil)
+	p.Release()
+
+	return &MonoVoice{
+		playbackEvent:       p,
+		engine:              e,
+		notePriority:        LastNotePriority,
+		isLegatoGlide:       true,
+		portamentoMode:      ConstantTimePortamento,
+		filterEnvelopeDepth: p.filterEnvelopeDepth,
+	}, nil
+}
+
+// press a note (optionally accented)
+func (v *MonoVoice) NoteOn(note int, accent ...bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.isClosed {
+		return
+	}
+
+	isLegato := len(v.heldNotes) > 0
+	v.removeHeldNote(note)
+	v.heldNotes = append(v.heldNotes, note)
+
+	// accent
+	isAccented := accent != nil && accent[0]
+	if isAccented {
+		v.playbackEvent.SetGain(v.gain + v.accentGain)
+		v.playbackEvent.SetFilterEnvelopeDepth(v.filterEnvelopeDepth + v.accentFilterEnvelopeDepth)
+	} else {
+		v.playbackEvent.SetGain(v.gain)
+		v.playbackEvent.SetFilterEnvelopeDepth(v.filterEnvelopeDepth)
+	}
+
+	v.playNote(v.prioritizedNote(), isLegato || !v.isLegatoGlide)
+
+	// legato notes don't retrigger, other notes start the sample afresh
+	// (the event keeps ticking while released, so a one shot would
+	// otherwise have finished, and a loop would carry on from wherever
+	// it was)
+	if !isLegato {
+		v.Trigger()
+		v.Attack()
+	}
+
+	if !v.isPlaying {
+		v.engine.Play(v.playbackEvent)
+		v.isPlaying = true
+	}
+}
+
+// release a note
+func (v *MonoVoice) NoteOff(note int) {
+	v.Lock()
+	defer v.Unlock()
+
+	if !v.removeHeldNote(note) {
+		return
+	}
+	if len(v.heldNotes) == 0 {
+		v.Release()
+		return
+	}
+	// fall back to the next held note (legato)
+	v.playNote(v.prioritizedNote(), true)
+}
+
+// release every note
+func (v *MonoVoice) AllNotesOff() {
+	v.Lock()
+	defer v.Unlock()
+
+	if len(v.heldNotes) > 0 {
+		v.heldNotes = v.heldNotes[:0]
+		v.Release()
+	}
+}
+
+// change the note sounding (gliding to it if it's allowed to)
+func (v *MonoVoice) playNote(note int, canGlide bool) {
+	if v.hasNote && note == v.currentNote {
+		return
+	}
+
+	glideTime := 0.0
+	if canGlide && v.hasNote {
+		glideTime = v.glideTime
+		if v.portamentoMode == ConstantRatePortamento {
+			glideTime *= math.Abs(float64(note-v.currentNote)) / 12.0
+		}
+	}
+
+	v.SetNote(note, glideTime)
+	v.currentNote = note
+	v.hasNote = true
+}
+
+// the held note which sounds (given the note priority)
+func (v *MonoVoice) prioritizedNote() int {
+	note := v.heldNotes[len(v.heldNotes)-1]
+	switch v.notePriority {
+	case LowNotePriority:
+		for _, n := range v.heldNotes {
+			if n < note {
+				note = n
+			}
+		}
+	case HighNotePriority:
+		for _, n := range v.heldNotes {
+			if n > note {
+				note = n
+			}
+		}
+	}
+	return note
+}
+
+// remove a note from the held notes, returning whether it was held
+func (v *MonoVoice) removeHeldNote(note int) bool {
+	for i, n := range v.heldNotes {
+		if n == note {
+			v.heldNotes = append(v.heldNotes[:i], v.heldNotes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// set which held note sounds (LastNotePriority, LowNotePriority,
+// HighNotePriority)
+func (v *MonoVoice) SetNotePriority(notePriority NotePriority) {
+	v.Lock()
+	defer v.Unlock()
+	v.notePriority = notePriority
+}
+
+// set the glide (portamento) time, in seconds (ConstantTimePortamento) or
+// seconds per octave (ConstantRatePortamento), 0 turns off gliding
+func (v *MonoVoice) SetGlide(glideTimeInSeconds float64) {
+	v.Lock()
+	defer v.Unlock()
+	v.glideTime = math.Max(glideTimeInSeconds, 0.0)
+}
+
+// set whether the glide time is constant, or a rate (per octave)
+func (v *MonoVoice) SetPortamentoMode(portamentoMode PortamentoMode) {
+	v.Lock()
+	defer v.Unlock()
+	v.portamentoMode = portamentoMode
+}
+
+// set whether only legato notes glide (the default), or every note does
+func (v *MonoVoice) SetLegatoGlide(isLegatoGlide bool) {
+	v.Lock()
+	defer v.Unlock()
+	v.isLegatoGlide = isLegatoGlide
+}
+
+// set how much louder (in db) accented notes are, and how much filter
+// envelope depth they add
+func (v *MonoVoice) SetAccent(gainInDecibels, filterEnvelopeDepth float64) {
+	v.Lock()
+	defer v.Unlock()
+	v.accentGain = gainInDecibels
+	v.accentFilterEnvelopeDepth = filterEnvelopeDepth
+}
+
+// set the gain (in db) of the (unaccented) notes
+func (v *MonoVoice) SetGain(db float64) {
+	v.Lock()
+	defer v.Unlock()
+	v.gain = db
+	v.playbackEvent.SetGain(db)
+}
+
+// set the filter envelope depth of the (unaccented) notes
+func (v *MonoVoice) SetFilterEnvelopeDepth(filterEnvelopeDepth float64) {
+	v.Lock()
+	defer v.Unlock()
+	v.filterEnvelopeDepth = filterEnvelopeDepth
+	v.playbackEvent.SetFilterEnvelopeDepth(filterEnvelopeDepth)
+}
+
+// stop the voice (releasing it, and removing it from the engine once it's
+// released).  It can't be played after.
+func (v *MonoVoice) Close() {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.isClosed {
+		return
+	}
+	v.isClosed = true
+	v.heldNotes = v.heldNotes[:0]
+	v.amplitudeADSREnvelope.setDoneAction(v.engine.newPlaybackEventDeactivator(v.playbackEvent))
+	v.Release()
+}
@@ -0,0 +1,77 @@
+package stereophonic
+
+import (
+	"sync/atomic"
+)
+
+// playback event lifecycle notifications
+//
+// A playback event can notify when things happen during its playback (its
+// delay ended, it started, its loop wrapped, it reached the end, its release
+// began, it's done).  They're sent (from the audio thread) on a buffered
+// channel, without ever blocking: if the channel is full, the notification is
+// dropped.  So read them promptly (on another goroutine).
+//
+// Each notification has the frame it happened at, counted from when the
+// event began playing (its delay included).
+//
+// ex:
+//  event, _ := e.Prepare(slot, 0, 0)
+//  notifications := event.Notifications()
+//  e.Play(event)
+//  go func() {
+//      for n := range notifications { ... }
+//  }()
+//
+// NB. the channel is never closed (DoneNotification is the last one sent).
+
+type PlaybackNotificationType int
+
+const (
+	DelayEndedNotification PlaybackNotificationType = iota
+	StartedNotification
+	LoopWrappedNotification
+	ReachedEndNotification
+	ReleaseBeganNotification
+	DoneNotification
+)
+
+const (
+	// how many notifications are buffered (by default) before dropping
+	defaultNotificationBufferSize int = 64
+)
+
+type PlaybackNotification struct {
+	Type PlaybackNotificationType
+	// the frame it happened at (counted from when the event began playing)
+	Frame int64
+}
+
+// get the channel of notifications, an optional buffer size can be given
+// (64 by default).  NB. call this before playback begins, every call returns
+// the same channel
+func (tp *tablePlayer) Notifications(bufferSize ...int) <-chan PlaybackNotification {
+	if tp.notifications == nil {
+		size := defaultNotificationBufferSize
+		if bufferSize != nil && bufferSize[0] > 0 {
+			size = bufferSize[0]
+		}
+		tp.notifications = make(chan PlaybackNotification, size)
+	}
+	return tp.notifications
+}
+
+// send a notification (if anyone's subscribed) without blocking
+func (tp *tablePlayer) notify(notificationType PlaybackNotificationType) {
+	if tp.notifications == nil {
+		return
+	}
+	select {
+	case tp.notifications <- PlaybackNotification{
+		Type:  notificationType,
+		Frame: atomic.LoadInt64(&tp.elapsedFrames),
+	}:
+	default:
+		// dropped
+	}
+}
@@ -0,0 +1,79 @@
+package stereophonic
+
+// output channels
+//
+// The output device can have any number of channels (by default stereo, or
+// mono if that's all the device has).  Playback events play on a pair of
+// output channels, pair 0 (channels 1 & 2) by default.  Pair 1 is channels 3 &
+// 4, pair 2 is channels 5 & 6, and so on.
+//
+// A pair which only has its first channel (ie. the last pair of a device with
+// an odd number of channels, or a mono device) plays the event folded down to
+// mono ((left + right) / 2).  A pair the device doesn't have at all plays on
+// pair 0.
+//
+// The input is monitored on pair 0, and the master meters (and spectrum
+// analysis) measure pair 0.  Recordings record every output channel.
+//
+// ex:
+//  e.SetOutputChannels(4)
+//  event.SetOutputPair(1) // <--- channels 3 & 4
+
+// sets how many output channels we want our output device to play (from 1 to
+// the device's maximum).  If there is currently no output device, this
+// function errors.
+func (e *Engine) SetOutputChannels(numberOfChannels int) error {
+	if !e.initialized {
+		return errorEngineNotInitialized
+	}
+	// return error if the output device does not exist
+	if e.streamParameters.Output.Device == nil {
+		return errorDeviceDoesNotExist
+	}
+	if numberOfChannels < 1 || numberOfChannels > e.streamParameters.Output.Device.MaxOutputChannels {
+		return errorUnsupportedNumberOfChannels
+	}
+	e.streamParameters.Output.Channels = numberOfChannels
+	return nil
+}
+
+// the preferred number of output channels of a device (stereo, unless it's
+// mono)
+func preferredOutputChannels(maxOutputChannels int) int {
+	if maxOutputChannels == 1 {
+		return 1
+	}
+	return 2
+}
+
+// set which pair of output channels the playback event plays on (pair n is
+// channels 2n+1 & 2n+2)
+func (tp *tablePlayer) SetOutputPair(pair int) {
+	if pair < 0 {
+		pair = 0
+	}
+	tp.outputPair = pair
+}
+
+// accumulate a (stereo) frame into a pair of channels of an output frame
+func mixFrame(frame []float32, pair int, left, right float64) {
+	c := 2 * pair
+	if c >= len(frame) {
+		c = 0
+	}
+	if c+1 < len(frame) {
+		frame[c] += float32(left)
+		frame[c+1] += float32(right)
+	} else {
+		// fold down to mono
+		frame[c] += float32(0.5 * (left + right))
+	}
+}
+
+// the (stereo) frame of pair 0 of an output frame
+func stereoFrame(frame []float32) (float64, float64) {
+	if len(frame) == 1 {
+		return float64(frame[0]), float64(frame[0])
+	}
+	return float64(frame[0]), float64(frame[1])
+}
@@ -0,0 +1,151 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// panning
+//
+// Unlike the balance (which only attenuates 1 side), panning moves the
+// source between the speakers, keeping its loudness (more or less) constant
+// with a pan law.  The pan law is how much a source is attenuated in the
+// center (relative to hard left/right):
+// -3db:   equal power (sin/cos), constant loudness for uncorrelated speakers
+// -6db:   linear (equal gain), constant loudness for summing to mono
+// -4.5db: a compromise between the 2 (the geometric mean of them)
+//
+// A mono table is panned as a single source.  A stereo table has its width
+// adjusted first (0 => mono, 1 => unchanged, 2 => extra wide, by scaling its
+// side signal) then its left and right channels are panned (as 2 sources)
+// such that at a pan of 0 it's unchanged, and otherwise its image moves
+// (and narrows) towards 1 side.
+//
+// The pan and width are smoothed (so moving them in realtime doesn't click).
+// Panning is off until SetPan() or SetStereoWidth() are called, as the
+// center of a pan law attenuates mono tables.
+//
+// more info here:
+// http://www.cs.cmu.edu/~music/icm-online/readings/panlaws/
+
+type PanLaw int
+
+const (
+	PanLawMinus3dB PanLaw = iota
+	PanLawMinus4Point5dB
+	PanLawMinus6dB
+)
+
+const (
+	// how long the pan (and width) take to settle when they change
+	defaultPanSmoothingInSeconds float64 = 0.01
+	// the widest stereo width
+	maximumStereoWidth float64 = 2.0
+)
+
+// the gains of the left and right speakers of a source at pan position
+// (-1 hard left, 0 center, 1 hard right)
+func panGains(pan float64, panLaw PanLaw) (float64, float64) {
+	x := (math.Max(-1.0, math.Min(pan, 1.0)) + 1.0) / 2.0
+	left, right := math.Cos(x*math.Pi/2.0), math.Sin(x*math.Pi/2.0)
+	switch panLaw {
+	case PanLawMinus6dB:
+		return 1.0 - x, x
+	case PanLawMinus4Point5dB:
+		return math.Sqrt(left * (1.0 - x)), math.Sqrt(right * x)
+	default: // PanLawMinus3dB
+		return left, right
+	}
+}
+
+// compute the (target) matrix which pans (and widens) a frame
+func (tp *tablePlayer) calculatePanMatrix() {
+	tp.targetPanMatrix = panMatrix(tp.table.channels, tp.pan, tp.stereoWidth, tp.panLaw)
+}
+
+// the matrix which pans (and widens) a frame of a mono or stereo source
+func panMatrix(channels int, pan, width float64, panLaw PanLaw) [4]float64 {
+	if channels == 1 {
+		// mono frames are the same on both sides, so only pan
+		left, right := panGains(pan, panLaw)
+		return [4]float64{left, 0.0, 0.0, right}
+	}
+
+	// widen, by scaling the side signal (l - r)/2 by the width
+	var (
+		same    = (1.0 + width) / 2.0
+		crossed = (1.0 - width) / 2.0
+	)
+	// pan the left and right channels either side of the pan position,
+	// they're spread hard left/right at the center and converge as the
+	// pan reaches either side
+	spread := 1.0 - math.Abs(pan)
+	leftLeft, leftRight := panGains(pan-spread, panLaw)
+	rightLeft, rightRight := panGains(pan+spread, panLaw)
+	// at a pan of 0 (and width 1) this is the identity
+	return [4]float64{
+		same*leftLeft + crossed*rightLeft,
+		crossed*leftLeft + same*rightLeft,
+		same*leftRight + crossed*rightRight,
+		crossed*leftRight + same*rightRight,
+	}
+}
+
+// pan the frame, moving the matrix (smoothly) towards its target
+func (tp *tablePlayer) panFrame(left, right float64) (float64, float64) {
+	for n := range tp.panMatrix {
+		tp.panMatrix[n] += (tp.targetPanMatrix[n] - tp.panMatrix[n]) * tp.panSmoothingFactor
+	}
+	m := tp.panMatrix
+	return m[0]*left + m[1]*right, m[2]*left + m[3]*right
+}
+
+// start panning (if it's off) and update the target matrix
+func (tp *tablePlayer) startPanning() {
+	tp.calculatePanMatrix()
+	if !tp.isPanning {
+		// the first pan is usually set before playback, so start there
+		// (rather than smoothing towards it)
+		tp.panMatrix = tp.targetPanMatrix
+		tp.isPanning = true
+	}
+}
+
+// set the pan position
+// -1: hard left
+//  0: center
+//  1: hard right
+func (tp *tablePlayer) SetPan(pan float64) {
+	tp.pan = math.Max(-1.0, math.Min(pan, 1.0))
+	tp.startPanning()
+}
+
+// set the pan law (how much the center is attenuated)
+func (tp *tablePlayer) SetPanLaw(panLaw PanLaw) {
+	tp.panLaw = panLaw
+	if tp.isPanning {
+		tp.calculatePanMatrix()
+	}
+}
+
+// set the stereo width of a stereo table
+// 0: mono
+// 1: unchanged
+// 2: extra wide
+func (tp *tablePlayer) SetStereoWidth(width float64) {
+	tp.stereoWidth = math.Max(0.0, math.Min(width, maximumStereoWidth))
+	tp.startPanning()
+}
+
+// set how long (in seconds) the pan and width take to settle when changed
+func (tp *tablePlayer) SetPanSmoothing(smoothingTimeInSeconds float64) {
+	tp.panSmoothingFactor = smoothingFactor(smoothingTimeInSeconds, tp.sampleRate)
+}
+
+// the factor (per frame) with which a one pole smoother approaches its target
+// for a time (constant) in seconds
+func smoothingFactor(timeInSeconds, sampleRate float64) float64 {
+	if timeInSeconds <= 0.0 {
+		return 1.0
+	}
+	return 1.0 - math.Exp(-1.0/(timeInSeconds*sampleRate))
+}
@@ -2,6 +2,7 @@ package stereophonic
 
 import (
 	"math"
+	"sync/atomic"
 )
 
 const (
@@ -117,6 +118,11 @@ func (e *Engine) Prepare(slot int, delayInSeconds, durationInSeconds float64) (*
 		}
 	}
 
+	// start with the loops/tuning of the sound file (if so configured)
+	if e.applySampleMetadata {
+		p.ApplySampleMetadata()
+	}
+
 	// attach a callback which removes this playback event from the
 	// engine's active playback events once it's "done" (finished duration
 	// or released)
@@ -161,8 +167,10 @@ retry:
 		if p.delayInFrames > 0 {
 			// decrement remaining ticks
 			p.delayInFrames--
+			atomic.AddInt64(&p.elapsedFrames, 1)
 			left, right = 0.0, 0.0
 		} else {
+			p.notify(DelayEndedNotification)
 			// else there are no more (delay) frames to tick
 			// change the playback state to unlimited/limited duration
 			if p.isLimitedDuration {
@@ -175,5 +183,14 @@ retry:
 		}
 	}
 
+	// meter the event (if metering)
+	if m := p.currentMeter(); m != nil {
+		m.tick(left, right)
+	}
+	// and analyze its spectrum (if analyzing)
+	if a := p.currentSpectrumAnalyzer(); a != nil {
+		a.tick(left, right)
+	}
+
 	return left, right
 }
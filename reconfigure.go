@@ -0,0 +1,264 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// reconfiguring the stream while it's running
+//
+// SetSampleRate(), SetFramesPerBuffer(), SetDevices() and SetInputChannels()
+// only take effect when the stream is (re)opened.  Rather than Stop() and
+// Start() (which loses whatever is playing), Reconfigure() reopens the stream
+// in place:
+// 1. the output fades out (briefly)
+// 2. the stream is closed and reopened with the new stream parameters
+// 3. playing (and queued) events are rebased to the new sample rate, so
+//    their remaining delay/duration, envelopes, filters, etc. take as long
+//    (and sound the same) as they would have.  Events prepared earlier (but
+//    not yet played) are rebased when they're played.
+// 4. the output fades back in
+//
+// Should the device reject the new stream parameters, the stream carries on
+// (or is reopened) with the previous ones, and a descriptive error is
+// returned.  Should even that fail, the engine is left stopped (with the
+// stream closed) and the error says what failed, so it can be Start()ed again.
+//
+// ex:
+//  e.SetSampleRate(96000)
+//  e.SetFramesPerBuffer(128)
+//  if err := e.Reconfigure(); err != nil { ... }
+
+const (
+	// how long the output fades out (and back in) when reconfiguring
+	reconfigureFadeInSeconds float64 = 0.01
+	// how long we wait (at most) for the fade out to finish
+	reconfigureFadeTimeout = 500 * time.Millisecond
+)
+
+var (
+	errorStreamParametersRejected  error = fmt.Errorf("the device rejected the stream parameters")
+	errorReconfigureWhileRecording error = fmt.Errorf("can't reconfigure the stream while recording or sampling")
+	errorReconfigureWhileLooping   error = fmt.Errorf("can't reconfigure the stream while looping")
+)
+
+// describe stream parameters (for errors)
+func describeStreamParameters(p portaudio.StreamParameters) string {
+	description := fmt.Sprintf("%.0fhz, %d frames per buffer", p.SampleRate, p.FramesPerBuffer)
+	if p.Output.Device != nil {
+		description += fmt.Sprintf(", %d output channels on %q", p.Output.Channels, p.Output.Device.Name)
+	}
+	if p.Input.Device != nil {
+		description += fmt.Sprintf(", %d input channels on %q", p.Input.Channels, p.Input.Device.Name)
+	}
+	return description
+}
+
+// reopen the (started) stream with the current stream parameters, fading out
+// and back in, and rebasing what's playing to the new sample rate.  If the
+// engine isn't started, there's nothing to do (the stream parameters take
+// effect on Start()).
+func (e *Engine) Reconfigure() error {
+	e.Lock()
+	defer e.Unlock()
+
+	if !e.initialized {
+		return errorEngineNotInitialized
+	}
+	if !e.started {
+		return nil
+	}
+
+	// recordings/samples have a fixed sample rate and channels
+	if r, _ := e.recorder.Load().(*recorder); r != nil {
+		return errorReconfigureWhileRecording
+	}
+	if s, _ := e.sampler.Load().(*sampler); s != nil {
+		return errorReconfigureWhileRecording
+	}
+	// and so do loops (and their bars)
+	if atomic.LoadInt32(&e.looper.state) != looperIdle {
+		return errorReconfigureWhileLooping
+	}
+
+	// ask first (while the stream is still running)
+	if err := portaudio.IsFormatSupported(e.streamParameters, e.streamCallback); err != nil {
+		rejected := e.streamParameters
+		e.streamParameters = e.openStreamParameters
+		return fmt.Errorf("%w (%s): %v", errorStreamParametersRejected, describeStreamParameters(rejected), err)
+	}
+
+	// fade out, and close the stream
+	e.fadeOut()
+	if err := e.stream.Stop(); err != nil {
+		// carry on (fading back in)
+		atomic.StoreInt32(&e.isFadingOut, 0)
+		return err
+	}
+	e.started = false
+	previous := e.openStreamParameters
+	// should closing fail, the stream is stopped all the same, so we carry
+	// on reopening it (and report the failure)
+	var closeErr error
+	if err := e.stream.Close(); err != nil {
+		closeErr = fmt.Errorf("closing the stream (%s) failed: %v", describeStreamParameters(previous), err)
+	}
+	e.stream = nil
+
+	// reopen it
+	stream, err := portaudio.OpenStream(e.streamParameters, e.streamCallback)
+	if err == nil {
+		if err = e.startReconfiguredStream(stream); err == nil {
+			return closeErr
+		}
+	}
+
+	// the device rejected the new stream parameters after all, so reopen
+	// it with the previous ones
+	err = fmt.Errorf("%w (%s): %v", errorStreamParametersRejected, describeStreamParameters(e.streamParameters), err)
+	if closeErr != nil {
+		err = fmt.Errorf("%v, and %v", closeErr, err)
+	}
+	e.streamParameters = previous
+	if stream, reopenErr := portaudio.OpenStream(e.streamParameters, e.streamCallback); reopenErr != nil {
+		return fmt.Errorf("%v, and reopening the stream (%s) failed: %v", err, describeStreamParameters(e.streamParameters), reopenErr)
+	} else if startErr := e.startReconfiguredStream(stream); startErr != nil {
+		return fmt.Errorf("%v, and restarting the stream (%s) failed: %v", err, describeStreamParameters(e.streamParameters), startErr)
+	}
+	return err
+}
+
+// rebase everything to the (opened, not started) stream's sample rate, then
+// start it (fading in).  Should that fail, the stream is closed (so the engine
+// is left stopped, not half started).
+func (e *Engine) startReconfiguredStream(stream *portaudio.Stream) error {
+	if err := e.rebaseReconfiguredStream(stream); err != nil {
+		if closeErr := stream.Close(); closeErr != nil {
+			return fmt.Errorf("%v (and closing the stream failed: %v)", err, closeErr)
+		}
+		return err
+	}
+	e.stream = stream
+	e.started = true
+	return nil
+}
+
+// (the implementation of startReconfiguredStream)
+func (e *Engine) rebaseReconfiguredStream(stream *portaudio.Stream) error {
+	e.openStreamParameters = e.streamParameters
+
+	if sampleRate := stream.Info().SampleRate; sampleRate != e.streamSampleRate {
+		e.streamSampleRate = sampleRate
+		// (the stream callback isn't running, so we can touch the active
+		// events) play what's queued, and rebase everything playing
+		for len(e.newPlaybackEvents) > 0 {
+			e.activePlaybackEvents[<-e.newPlaybackEvents] = true
+		}
+		for playbackEvent := range e.activePlaybackEvents {
+			playbackEvent.setSampleRate(sampleRate)
+		}
+		e.masterMeter.setSampleRate(sampleRate)
+		e.inputMeter.setSampleRate(sampleRate)
+		e.input.setSampleRate(sampleRate)
+		if a, _ := e.spectrumAnalyzer.Load().(*SpectrumAnalyzer); a != nil {
+			a.setSampleRate(sampleRate)
+		}
+		if err := e.resampleTables(); err != nil {
+			return err
+		}
+	}
+	e.stats.setSampleRate(e.streamSampleRate)
+	e.fadeIncrement = 1.0 / math.Max(reconfigureFadeInSeconds*e.streamSampleRate, 1.0)
+
+	// fade in
+	e.fadeGain = 0.0
+	atomic.StoreInt32(&e.isFadedOut, 0)
+	atomic.StoreInt32(&e.isFadingOut, 0)
+
+	return stream.Start()
+}
+
+// fade out the output, waiting until it's silent (or the fade times out,
+// should the stream callback not be called)
+func (e *Engine) fadeOut() {
+	atomic.StoreInt32(&e.isFadingOut, 1)
+	deadline := time.Now().Add(reconfigureFadeTimeout)
+	for atomic.LoadInt32(&e.isFadedOut) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// apply the fade (out or in) to the (interleaved) output (called from the
+// audio thread)
+func (e *Engine) fade(out []float32, channels int) {
+	isFadingOut := atomic.LoadInt32(&e.isFadingOut) != 0
+	if !isFadingOut && e.fadeGain >= 1.0 {
+		return
+	}
+	for n := 0; n < len(out); n += channels {
+		if isFadingOut {
+			e.fadeGain = math.Max(e.fadeGain-e.fadeIncrement, 0.0)
+		} else {
+			e.fadeGain = math.Min(e.fadeGain+e.fadeIncrement, 1.0)
+		}
+		for c := n; c < n+channels; c++ {
+			out[c] *= float32(e.fadeGain)
+		}
+	}
+	if isFadingOut && e.fadeGain == 0.0 {
+		atomic.StoreInt32(&e.isFadedOut, 1)
+	}
+}
+
+// change the sample rate of the event, keeping its remaining delay and
+// duration the same in seconds
+func (p *playbackEvent) setSampleRate(sampleRate float64) {
+	ratio := sampleRate / p.sampleRate
+	p.delayInFrames = int(float64(p.delayInFrames) * ratio)
+	p.durationInFrames = int(float64(p.durationInFrames) * ratio)
+	p.tablePlayer.setSampleRate(sampleRate)
+}
+
+// change the sample rate of the table player, keeping it sounding the same
+// (its speed, envelopes, filters, smoothing, etc.)
+func (tp *tablePlayer) setSampleRate(sampleRate float64) {
+	if sampleRate == tp.sampleRate {
+		return
+	}
+	ratio := sampleRate / tp.sampleRate
+
+	// playback rate (and slides) per frame
+	tp.srFactor = tp.table.sampleRate / sampleRate
+	tp.phaseIncrement /= ratio
+	tp.targetPhaseIncrement /= ratio
+	tp.slideFactor /= ratio * ratio
+
+	// one pole smoothing, (1 - factor) is e^(-1/(time*sampleRate))
+	tp.panSmoothingFactor = 1.0 - math.Pow(1.0-tp.panSmoothingFactor, 1.0/ratio)
+
+	tp.amplitudeADSREnvelope.setSampleRate(sampleRate)
+	tp.filterADSREnvelope.setSampleRate(sampleRate)
+	tp.filterCutoff = tp.filterLeft.rebasedCutoff(tp.filterCutoff, sampleRate)
+	tp.filterLeft.setSampleRate(sampleRate)
+	tp.filterRight.setSampleRate(sampleRate)
+	if tp.stretcher != nil {
+		tp.stretcher.setSampleRate(sampleRate)
+	}
+	if tp.granulator != nil {
+		tp.granulator.setSampleRate(sampleRate)
+	}
+	tp.kMaxTicks = int(sampleRate/tp.kRate + 1)
+	tp.kCurrentTick %= tp.kMaxTicks
+	if m := tp.currentMeter(); m != nil {
+		m.setSampleRate(sampleRate)
+	}
+	if a := tp.currentSpectrumAnalyzer(); a != nil {
+		a.setSampleRate(sampleRate)
+	}
+
+	tp.sampleRate = sampleRate
+}
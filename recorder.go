@@ -0,0 +1,220 @@
+package stereophonic
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mkb218/gosndfile/sndfile"
+)
+
+// recording of the engine's (master) output to a sound file
+//
+// The stream callback hands each output buffer to a lock-free ring, which a
+// writer goroutine drains (to disk) with libsndfile.  The audio thread never
+// waits on the disk.  Should the disk fall too far behind (the ring is full)
+// audio is dropped from the recording (but not from the output!) and
+// StopRecording() reports it.
+
+// recording file formats
+type RecordingFormat int
+
+const (
+	RecordWAV16 RecordingFormat = iota
+	RecordWAV24
+	RecordWAV32Float
+	RecordAIFF16
+	RecordAIFF24
+	RecordAIFF32Float
+	RecordFLAC16
+	RecordFLAC24
+)
+
+const (
+	// how much audio the ring between the audio thread and the writer
+	// goroutine holds
+	recordingBufferInSeconds float64 = 4.0
+	// how often the writer goroutine drains the ring
+	recordingPollInterval = 20 * time.Millisecond
+)
+
+var (
+	errorAlreadyRecording           error = fmt.Errorf("engine is already recording")
+	errorNotRecording               error = fmt.Errorf("engine isn't recording")
+	errorUnsupportedRecordingFormat error = fmt.Errorf("unsupported recording format")
+	errorRecordingDroppedAudio      error = fmt.Errorf("recording dropped audio (the disk couldn't keep up)")
+)
+
+// the libsndfile format of a recording format
+func (f RecordingFormat) sndfileFormat() (sndfile.Format, error) {
+	switch f {
+	case RecordWAV16:
+		return sndfile.SF_FORMAT_WAV | sndfile.SF_FORMAT_PCM_16, nil
+	case RecordWAV24:
+		return sndfile.SF_FORMAT_WAV | sndfile.SF_FORMAT_PCM_24, nil
+	case RecordWAV32Float:
+		return sndfile.SF_FORMAT_WAV | sndfile.SF_FORMAT_FLOAT, nil
+	case RecordAIFF16:
+		return sndfile.SF_FORMAT_AIFF | sndfile.SF_FORMAT_PCM_16, nil
+	case RecordAIFF24:
+		return sndfile.SF_FORMAT_AIFF | sndfile.SF_FORMAT_PCM_24, nil
+	case RecordAIFF32Float:
+		return sndfile.SF_FORMAT_AIFF | sndfile.SF_FORMAT_FLOAT, nil
+	case RecordFLAC16:
+		return sndfile.SF_FORMAT_FLAC | sndfile.SF_FORMAT_PCM_16, nil
+	case RecordFLAC24:
+		return sndfile.SF_FORMAT_FLAC | sndfile.SF_FORMAT_PCM_24, nil
+	default:
+		return 0, errorUnsupportedRecordingFormat
+	}
+}
+
+type recorder struct {
+	// the sound file we write to
+	file     *sndfile.File
+	channels int
+	// audio from the stream callback (waiting to be written)
+	ring *sampleRing
+	// scratch buffer for draining the ring
+	buffer []float32
+	// flag set (atomically, by the audio thread) when the ring overflowed
+	droppedAudio int32
+	// closed to stop the writer goroutine
+	stop chan struct{}
+	// closed by the writer goroutine once it's finished (err is then valid)
+	finished chan struct{}
+	err      error
+}
+
+// create a recorder (writing to a new sound file)
+func newRecorder(fileName string, format RecordingFormat, channels int, sampleRate float64) (*recorder, error) {
+
+	sfFormat, err := format.sndfileFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	info := sndfile.Info{
+		Samplerate: int32(sampleRate),
+		Channels:   int32(channels),
+		Format:     sfFormat,
+	}
+	file, err := sndfile.Open(fileName, sndfile.Write, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := int(recordingBufferInSeconds*sampleRate) * channels
+	return &recorder{
+		file:     file,
+		channels: channels,
+		ring:     newSampleRing(capacity),
+		buffer:   make([]float32, capacity),
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}, nil
+}
+
+// hand a buffer of (interleaved) output to the recorder
+// called from the audio thread, so it never blocks
+func (r *recorder) record(out []float32) {
+	if !r.ring.write(out) {
+		atomic.StoreInt32(&r.droppedAudio, 1)
+	}
+}
+
+// the writer goroutine
+func (r *recorder) run() {
+	ticker := time.NewTicker(recordingPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			// write whatever is left, then finish the file
+			r.drain()
+			if err := r.file.Close(); err != nil && r.err == nil {
+				r.err = err
+			}
+			close(r.finished)
+			return
+		case <-ticker.C:
+			r.drain()
+		}
+	}
+}
+
+// write everything in the ring to the sound file
+func (r *recorder) drain() {
+	for {
+		// only read whole frames
+		n := r.ring.available()
+		n -= n % r.channels
+		if n == 0 {
+			return
+		}
+		n = r.ring.readInto(r.buffer[:n])
+		if _, err := r.file.WriteFrames(r.buffer[:n]); err != nil && r.err == nil {
+			r.err = err
+		}
+	}
+}
+
+// start recording the engine's output to a sound file
+// the engine must be started (to know the sample rate of the recording)
+// ex:
+//  e.StartRecording("performance.wav", stereophonic.RecordWAV24)
+//  ...
+//  e.StopRecording()
+func (e *Engine) StartRecording(fileName string, format RecordingFormat) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if !e.started {
+		return errorEngineNotStarted
+	}
+	if r, _ := e.recorder.Load().(*recorder); r != nil {
+		return errorAlreadyRecording
+	}
+
+	// (recording every output channel)
+	r, err := newRecorder(fileName, format, e.openStreamParameters.Output.Channels, e.streamSampleRate)
+	if err != nil {
+		return err
+	}
+	go r.run()
+	// from here on out, the stream callback records
+	e.recorder.Store(r)
+
+	return nil
+}
+
+// stop recording the engine's output, this waits until the recording is
+// completely written to disk.  An error is returned if writing failed, or if
+// audio had to be dropped from the recording.
+func (e *Engine) StopRecording() error {
+	e.Lock()
+	defer e.Unlock()
+	return e.stopRecording()
+}
+
+// (the unlocked implementation of StopRecording)
+func (e *Engine) stopRecording() error {
+	r, _ := e.recorder.Load().(*recorder)
+	if r == nil {
+		return errorNotRecording
+	}
+	// detach it from the stream callback
+	e.recorder.Store((*recorder)(nil))
+
+	// finish writing
+	close(r.stop)
+	<-r.finished
+
+	if r.err != nil {
+		return r.err
+	}
+	if atomic.LoadInt32(&r.droppedAudio) == 1 {
+		return errorRecordingDroppedAudio
+	}
+	return nil
+}
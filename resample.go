@@ -0,0 +1,155 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// high quality sample rate conversion of tables
+//
+// A tablePlayer compensates for a table/stream sample rate mismatch with its
+// srFactor (at playback time) which (with truncating interpolation) aliases
+// quite audibly.  Instead, tables can be converted up front with a
+// (windowed-sinc) bandlimited interpolator, as described here:
+// https://ccrma.stanford.edu/~jos/resample/
+//
+// The sinc kernel is kaiser windowed and precomputed for a number of phases
+// (fractional positions) between each zero crossing, hence "polyphase".
+// Positions between phases are linearly interpolated.
+
+const (
+	// how many zero crossings (on each side) of the sinc kernel are used
+	resampleZeroCrossings int = 32
+	// how many phases of the kernel are computed per zero crossing
+	resamplePhases int = 512
+	// the kaiser window's beta (~ 90db stopband attenuation)
+	resampleKaiserBeta float64 = 8.6
+)
+
+var (
+	// the (one sided) kernel, computed once when first needed
+	resampleKernel     []float64
+	resampleKernelOnce sync.Once
+)
+
+// compute the (one sided) kaiser windowed sinc kernel
+// resampleKernel[k] is the kernel at k/resamplePhases zero crossings
+func computeResampleKernel() {
+	n := resampleZeroCrossings * resamplePhases
+	resampleKernel = make([]float64, n+1)
+	i0Beta := besselI0(resampleKaiserBeta)
+	for k := 0; k <= n; k++ {
+		x := float64(k) / float64(resamplePhases)
+		// sinc
+		sinc := 1.0
+		if k > 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		// kaiser window
+		r := x / float64(resampleZeroCrossings)
+		window := besselI0(resampleKaiserBeta*math.Sqrt(math.Max(0.0, 1.0-r*r))) / i0Beta
+		resampleKernel[k] = sinc * window
+	}
+}
+
+// the zeroth order modified bessel function of the first kind
+// (which the kaiser window is defined with) computed by its power series
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 64; k++ {
+		term *= (x / (2.0 * float64(k))) * (x / (2.0 * float64(k)))
+		sum += term
+		if term < sum*1e-16 {
+			break
+		}
+	}
+	return sum
+}
+
+// read the kernel at distance x (measured in zero crossings)
+func resampleKernelAt(x float64) float64 {
+	p := math.Abs(x) * float64(resamplePhases)
+	k := int(p)
+	if k >= len(resampleKernel)-1 {
+		return 0.0
+	}
+	// interpolate between phases
+	frac := p - float64(k)
+	return resampleKernel[k] + frac*(resampleKernel[k+1]-resampleKernel[k])
+}
+
+// create a new table which is this table converted to another sample rate
+// (the samples of this table are untouched)
+func (b *table) resample(sampleRate float64) (*table, error) {
+
+	// check that the sample rate is valid
+	if sampleRate < 1 {
+		return nil, fmt.Errorf("Cannot resample a table to sample rate: %f", sampleRate)
+	}
+	// streaming tables aren't in memory to resample
+	if b.streaming {
+		return nil, fmt.Errorf("Cannot resample a streaming table")
+	}
+
+	resampleKernelOnce.Do(computeResampleKernel)
+
+	b.Lock()
+	defer b.Unlock()
+
+	// nothing to do
+	if sampleRate == b.sampleRate {
+		return &table{
+			name:       b.name,
+			channels:   b.channels,
+			sampleRate: b.sampleRate,
+			samples:    b.samples,
+			nFrames:    b.nFrames,
+			metadata:   b.metadata,
+		}, nil
+	}
+
+	var (
+		// how many input frames elapse per output frame
+		step = b.sampleRate / sampleRate
+		// when downsampling, the kernel is stretched to cut off at the
+		// *new* nyquist frequency (avoiding aliasing)
+		cutoff = math.Min(1.0, sampleRate/b.sampleRate)
+		// how many input frames (on each side) the kernel spans
+		width   = int(math.Ceil(float64(resampleZeroCrossings) / cutoff))
+		nFrames = int(float64(b.nFrames) / step)
+		samples = make([]float64, nFrames*b.channels)
+	)
+
+	// each channel is independent, so convert them concurrently
+	var wg sync.WaitGroup
+	for c := 0; c < b.channels; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for n := 0; n < nFrames; n++ {
+				// position of this output frame in the input
+				t := float64(n) * step
+				i := int(t)
+				sum := 0.0
+				for j := i - width + 1; j <= i+width; j++ {
+					if j < 0 || j >= b.nFrames {
+						continue
+					}
+					sum += b.samples[j*b.channels+c] * resampleKernelAt((t-float64(j))*cutoff)
+				}
+				samples[n*b.channels+c] = sum * cutoff
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return &table{
+		name:       b.name,
+		channels:   b.channels,
+		sampleRate: sampleRate,
+		samples:    samples,
+		nFrames:    nFrames,
+		metadata:   b.metadata.scaled(1.0 / step),
+	}, nil
+}
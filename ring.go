@@ -0,0 +1,63 @@
+package stereophonic
+
+import (
+	"sync/atomic"
+)
+
+// a lock-free ring buffer of (interleaved) samples, for handing audio from the
+// audio thread to another goroutine (or vice versa) without blocking either.
+//
+// NB. it's only safe for 1 writer and 1 reader (concurrently)
+
+type sampleRing struct {
+	// total samples ever written/read (accessed atomically, and kept first
+	// for 64 bit alignment).  written - read is how many are readable.
+	written, read uint64
+	samples       []float32
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{
+		samples: make([]float32, capacity),
+	}
+}
+
+// write all of samples into the ring, or none of them (returning false) if
+// there isn't enough space
+func (r *sampleRing) write(samples []float32) bool {
+	written := atomic.LoadUint64(&r.written)
+	read := atomic.LoadUint64(&r.read)
+	if uint64(len(r.samples))-(written-read) < uint64(len(samples)) {
+		return false
+	}
+	capacity := uint64(len(r.samples))
+	for n, sample := range samples {
+		r.samples[(written+uint64(n))%capacity] = sample
+	}
+	// publish the samples
+	atomic.StoreUint64(&r.written, written+uint64(len(samples)))
+	return true
+}
+
+// read as many samples as are available (up to len(samples)), returning how
+// many were read
+func (r *sampleRing) readInto(samples []float32) int {
+	written := atomic.LoadUint64(&r.written)
+	read := atomic.LoadUint64(&r.read)
+	n := int(written - read)
+	if n > len(samples) {
+		n = len(samples)
+	}
+	capacity := uint64(len(r.samples))
+	for i := 0; i < n; i++ {
+		samples[i] = r.samples[(read+uint64(i))%capacity]
+	}
+	// free the space we read
+	atomic.StoreUint64(&r.read, read+uint64(n))
+	return n
+}
+
+// how many samples are readable
+func (r *sampleRing) available() int {
+	return int(atomic.LoadUint64(&r.written) - atomic.LoadUint64(&r.read))
+}
@@ -0,0 +1,265 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// sampling of audio input into a slot (like a hardware sampler)
+//
+// The stream callback hands each input buffer to a lock-free ring, which a
+// goroutine drains into a new table.  Sampling can begin immediately, or wait
+// until the input crosses a threshold (keeping some pre-roll audio from
+// before the threshold was crossed).  It lasts for a fixed duration or until
+// StopSampling() is called, after which the table is loaded into the slot,
+// ready to Prepare().
+
+const (
+	// how much input audio the ring between the audio thread and the
+	// sampling goroutine holds
+	samplingBufferInSeconds float64 = 4.0
+	// how often the sampling goroutine drains the ring
+	samplingPollInterval = 10 * time.Millisecond
+)
+
+var (
+	errorAlreadySampling      error = fmt.Errorf("engine is already sampling")
+	errorNotSampling          error = fmt.Errorf("engine isn't sampling")
+	errorNothingSampled       error = fmt.Errorf("nothing was sampled (the threshold was never crossed)")
+	errorSamplingDroppedAudio error = fmt.Errorf("sampling dropped audio (it couldn't keep up with the input)")
+)
+
+type sampler struct {
+	// the slot the sampled table is loaded into
+	slot       int
+	channels   int
+	sampleRate float64
+	// input from the stream callback (waiting to be sampled)
+	ring *sampleRing
+	// scratch buffer for draining the ring
+	buffer []float32
+	// how many frames to sample (<= 0 samples until stopped)
+	durationInFrames int
+	// the amplitude which triggers sampling (0 triggers immediately)
+	threshold float64
+	// the audio (before triggering) kept as pre-roll, in a circular buffer
+	preRoll        []float64
+	preRollWritten int
+	// whether the threshold was crossed (and sampling began)
+	triggered bool
+	// the sampled audio
+	samples []float64
+	// flag set (atomically, by the audio thread) when the ring overflowed
+	droppedAudio int32
+	// closed to stop the sampling goroutine
+	stop chan struct{}
+	// closed by the sampling goroutine once it's finished (err is then valid)
+	finished chan struct{}
+	err      error
+}
+
+// hand a buffer of (interleaved) input to the sampler
+// called from the audio thread, so it never blocks
+// (should the input source have changed channels, it's dropped)
+func (s *sampler) record(in []float32, channels int) {
+	if channels != s.channels || !s.ring.write(in) {
+		atomic.StoreInt32(&s.droppedAudio, 1)
+	}
+}
+
+// the sampling goroutine
+func (s *sampler) run(e *Engine) {
+	defer close(s.finished)
+
+	ticker := time.NewTicker(samplingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			s.drain()
+			s.finish(e)
+			return
+		case <-ticker.C:
+			// finish by ourselves once the duration is sampled
+			if s.drain() {
+				s.finish(e)
+				return
+			}
+		}
+	}
+}
+
+// sample everything in the ring, returning true when the duration is sampled
+func (s *sampler) drain() bool {
+	for {
+		// only read whole frames
+		n := s.ring.available()
+		n -= n % s.channels
+		if n == 0 {
+			return false
+		}
+		n = s.ring.readInto(s.buffer[:n])
+		for i := 0; i < n; i += s.channels {
+			if s.sampleFrame(s.buffer[i : i+s.channels]) {
+				return true
+			}
+		}
+	}
+}
+
+// sample 1 frame of input, returning true when the duration is sampled
+func (s *sampler) sampleFrame(frame []float32) bool {
+	if !s.triggered {
+		// check whether this frame crosses the threshold
+		for _, sample := range frame {
+			if math.Abs(float64(sample)) >= s.threshold {
+				s.trigger()
+				break
+			}
+		}
+		// otherwise keep it as pre-roll
+		if !s.triggered {
+			if len(s.preRoll) > 0 {
+				for _, sample := range frame {
+					s.preRoll[s.preRollWritten%len(s.preRoll)] = float64(sample)
+					s.preRollWritten++
+				}
+			}
+			return false
+		}
+	}
+	for _, sample := range frame {
+		s.samples = append(s.samples, float64(sample))
+	}
+	return s.durationInFrames > 0 && len(s.samples) >= s.durationInFrames*s.channels
+}
+
+// begin sampling, starting with the (oldest to newest) pre-roll
+func (s *sampler) trigger() {
+	s.triggered = true
+	n := len(s.preRoll)
+	if s.preRollWritten < n {
+		n = s.preRollWritten
+	}
+	for i := s.preRollWritten - n; i < s.preRollWritten; i++ {
+		s.samples = append(s.samples, s.preRoll[i%len(s.preRoll)])
+	}
+}
+
+// load the sampled audio into its slot (as a table)
+func (s *sampler) finish(e *Engine) {
+	e.Lock()
+	defer e.Unlock()
+
+	// detach from the stream callback (if StopSampling() hasn't already)
+	if current, _ := e.sampler.Load().(*sampler); current == s {
+		e.sampler.Store((*sampler)(nil))
+	}
+
+	if !s.triggered || len(s.samples) < s.channels {
+		s.err = errorNothingSampled
+		return
+	}
+	nFrames := len(s.samples) / s.channels
+	if s.durationInFrames > 0 && nFrames > s.durationInFrames {
+		nFrames = s.durationInFrames
+	}
+	e.tables[s.slot] = &table{
+		name:       fmt.Sprintf("sampled-input-%d", s.slot),
+		channels:   s.channels,
+		sampleRate: s.sampleRate,
+		samples:    s.samples[:nFrames*s.channels],
+		nFrames:    nFrames,
+	}
+	delete(e.originalTables, s.slot)
+}
+
+// start sampling audio input into a slot (requires an input device, see
+// SetDevices()).  When sampling finishes, the sampled audio replaces whatever
+// was loaded in the slot.
+//
+// durationInSeconds <= 0 samples until StopSampling() is called, otherwise
+// sampling finishes by itself after durationInSeconds (see IsSampling()).
+//
+// thresholdInDecibels delays sampling until the input is at least this loud,
+// pass stereophonic.GainNegativeInfinity to begin sampling immediately.  When
+// delayed, preRollInSeconds of the audio *before* the threshold was crossed is
+// kept at the start of the sample (so you don't lose the attack).
+// ex:
+//  // sample 2 seconds once the input reaches -30db (with 50ms of pre-roll)
+//  e.StartSampling(3, 2.0, -30.0, 0.05)
+func (e *Engine) StartSampling(slot int, durationInSeconds, thresholdInDecibels, preRollInSeconds float64) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if !e.started {
+		return errorEngineNotStarted
+	}
+	if e.streamParameters.Input.Device == nil {
+		return errorDeviceDoesNotExist
+	}
+	if s, _ := e.sampler.Load().(*sampler); s != nil {
+		return errorAlreadySampling
+	}
+
+	// the input source's channels (mono or stereo, see input.go)
+	_, _, channels := e.input.mappedChannels(e.openStreamParameters.Input.Channels)
+	capacity := int(samplingBufferInSeconds*e.streamSampleRate) * channels
+	s := &sampler{
+		slot:             slot,
+		channels:         channels,
+		sampleRate:       e.streamSampleRate,
+		ring:             newSampleRing(capacity),
+		buffer:           make([]float32, capacity),
+		durationInFrames: int(durationInSeconds * e.streamSampleRate),
+		threshold:        decibelsToAmplitude(thresholdInDecibels),
+		stop:             make(chan struct{}),
+		finished:         make(chan struct{}),
+	}
+	// pre-roll only makes sense when waiting for the threshold
+	if s.threshold > 0 && preRollInSeconds > 0 {
+		s.preRoll = make([]float64, int(preRollInSeconds*e.streamSampleRate)*channels)
+	}
+	go s.run(e)
+	// from here on out, the stream callback samples
+	e.sampler.Store(s)
+
+	return nil
+}
+
+// stop sampling audio input, loading what was sampled into the slot
+// this waits until the slot is loaded
+func (e *Engine) StopSampling() error {
+	e.Lock()
+	s, _ := e.sampler.Load().(*sampler)
+	if s == nil {
+		e.Unlock()
+		return errorNotSampling
+	}
+	// detach it from the stream callback
+	e.sampler.Store((*sampler)(nil))
+	// NB. unlock before waiting, as the sampler locks the engine to load
+	// the slot
+	e.Unlock()
+
+	close(s.stop)
+	<-s.finished
+
+	if s.err != nil {
+		return s.err
+	}
+	if atomic.LoadInt32(&s.droppedAudio) == 1 {
+		return errorSamplingDroppedAudio
+	}
+	return nil
+}
+
+// whether the engine is sampling audio input (a fixed duration sampling is
+// done when this returns false)
+func (e *Engine) IsSampling() bool {
+	s, _ := e.sampler.Load().(*sampler)
+	return s != nil
+}
@@ -0,0 +1,306 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+)
+
+// slicing of tables (like REX/Recycle)
+//
+// A table can be chopped into slices, either where transients (onsets) are
+// detected, or evenly by a number of beats.  Each slice can then be played by
+// its index (see SelectSlice() and PrepareSlice()) or exported into its own
+// slot.
+//
+// Onsets are detected with an onset detection function, computed per hop of
+// audio, whose peaks (above an adaptive threshold) are the onsets:
+// energy: the rise in (log) energy from the previous hop
+// spectral flux: the rise in (log) magnitude, summed over every frequency bin
+//
+// more info here:
+// https://www.eecs.qmul.ac.uk/~simond/pub/2005/ieee05.pdf
+
+// onset detection methods
+type OnsetDetection int
+
+const (
+	EnergyOnsets OnsetDetection = iota
+	SpectralFluxOnsets
+)
+
+const (
+	// the window (which must be a power of 2) and hop size of analysis
+	onsetWindowFrames int = 1024
+	onsetHopFrames    int = 512
+	// how many hops (on each side) the adaptive threshold averages
+	onsetThresholdHops int = 8
+	// onsets can't be closer than this
+	onsetMinimumIntervalInSeconds float64 = 0.05
+)
+
+var (
+	errorSliceDoesNotExist error = fmt.Errorf("slice does not exist")
+	errorInvalidSlices     error = fmt.Errorf("invalid number of slices")
+	errorStreamingTable    error = fmt.Errorf("unsupported for streaming tables")
+)
+
+// detect the onsets (as frame indices) in the table, the first onset is always
+// at frame 0.  sensitivity ranges from 0 (only the strongest onsets) to 1
+// (every little bump).
+func (b *table) detectOnsets(method OnsetDetection, sensitivity float64) []int {
+	sensitivity = math.Max(0.0, math.Min(sensitivity, 1.0))
+
+	// mono sum of the table
+	mono := make([]float64, b.nFrames)
+	for i := range mono {
+		for c := 0; c < b.channels; c++ {
+			mono[i] += b.samples[i*b.channels+c]
+		}
+	}
+
+	// compute the onset detection function (per hop)
+	var odf []float64
+	switch method {
+	case SpectralFluxOnsets:
+		odf = spectralFlux(mono)
+	default:
+		odf = energyRise(mono)
+	}
+
+	// normalize it
+	peak := 0.0
+	for _, x := range odf {
+		peak = math.Max(peak, x)
+	}
+	if peak == 0 {
+		return []int{0}
+	}
+	for n := range odf {
+		odf[n] /= peak
+	}
+
+	// pick peaks above the adaptive threshold (the local mean, plus a
+	// margin which shrinks as sensitivity increases)
+	var (
+		onsets      = []int{0}
+		margin      = 0.02 + 0.5*(1.0-sensitivity)
+		minInterval = int(onsetMinimumIntervalInSeconds * b.sampleRate)
+	)
+	for n := range odf {
+		// local maximum
+		isPeak := true
+		for k := n - 2; k <= n+2; k++ {
+			if k >= 0 && k < len(odf) && odf[k] > odf[n] {
+				isPeak = false
+			}
+		}
+		if !isPeak {
+			continue
+		}
+		// above the threshold
+		sum, count := 0.0, 0
+		for k := n - onsetThresholdHops; k <= n+onsetThresholdHops; k++ {
+			if k >= 0 && k < len(odf) {
+				sum += odf[k]
+				count++
+			}
+		}
+		if odf[n] < sum/float64(count)+margin {
+			continue
+		}
+		// far enough from the previous onset
+		onset := n * onsetHopFrames
+		if onset-onsets[len(onsets)-1] < minInterval {
+			continue
+		}
+		onsets = append(onsets, onset)
+	}
+
+	return onsets
+}
+
+// the rise in log energy of each hop
+func energyRise(mono []float64) []float64 {
+	nHops := len(mono) / onsetHopFrames
+	odf := make([]float64, nHops)
+	previous := 0.0
+	for n := 0; n < nHops; n++ {
+		energy := 0.0
+		for i := n * onsetHopFrames; i < n*onsetHopFrames+onsetWindowFrames && i < len(mono); i++ {
+			energy += mono[i] * mono[i]
+		}
+		current := math.Log(1.0 + energy)
+		odf[n] = math.Max(0.0, current-previous)
+		previous = current
+	}
+	return odf
+}
+
+// the (half wave rectified) spectral flux of each hop
+func spectralFlux(mono []float64) []float64 {
+	var (
+		nHops    = len(mono) / onsetHopFrames
+		odf      = make([]float64, nHops)
+		re       = make([]float64, onsetWindowFrames)
+		im       = make([]float64, onsetWindowFrames)
+		previous = make([]float64, onsetWindowFrames/2)
+	)
+	for n := 0; n < nHops; n++ {
+		// hann windowed frame
+		for k := range re {
+			i := n*onsetHopFrames + k
+			re[k], im[k] = 0.0, 0.0
+			if i < len(mono) {
+				w := 0.5 - 0.5*math.Cos(2.0*math.Pi*float64(k)/float64(onsetWindowFrames))
+				re[k] = mono[i] * w
+			}
+		}
+		fft(re, im)
+		// sum the rise in log magnitude of each bin
+		flux := 0.0
+		for k := range previous {
+			magnitude := math.Log(1.0 + math.Hypot(re[k], im[k]))
+			flux += math.Max(0.0, magnitude-previous[k])
+			previous[k] = magnitude
+		}
+		odf[n] = flux
+	}
+	return odf
+}
+
+// the start and (inclusive) end frame of slice n of the table
+func (b *table) slice(n int) (int, int, error) {
+	if n < 0 || n >= len(b.slices) {
+		return 0, 0, errorSliceDoesNotExist
+	}
+	start := b.slices[n]
+	end := b.nFrames - 1
+	if n+1 < len(b.slices) {
+		end = b.slices[n+1] - 1
+	}
+	return start, end, nil
+}
+
+// get the (in memory, so not streaming) table in a slot
+func (e *Engine) inMemoryTable(slot int) (*table, error) {
+	table, exists := e.tables[slot]
+	if !exists {
+		return nil, errorTableDoesNotExist
+	}
+	if table.streaming {
+		return nil, errorStreamingTable
+	}
+	return table, nil
+}
+
+// slice the sound file in a slot where onsets (transients) are detected,
+// returning the number of slices.  sensitivity ranges from 0 (only the
+// strongest onsets) to 1 (every little bump).
+func (e *Engine) SliceOnsets(slot int, method OnsetDetection, sensitivity float64) (int, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	table, err := e.inMemoryTable(slot)
+	if err != nil {
+		return 0, err
+	}
+	slices := table.detectOnsets(method, sensitivity)
+
+	table.Lock()
+	table.slices = slices
+	table.Unlock()
+
+	return len(slices), nil
+}
+
+// slice the sound file in a slot evenly into a number of beats
+// (ex. 16 for a bar of 16th notes), returning the number of slices
+func (e *Engine) SliceBeats(slot int, numberOfBeats int) (int, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	table, err := e.inMemoryTable(slot)
+	if err != nil {
+		return 0, err
+	}
+	if numberOfBeats < 1 || numberOfBeats > table.nFrames {
+		return 0, errorInvalidSlices
+	}
+	slices := make([]int, numberOfBeats)
+	for n := range slices {
+		slices[n] = n * table.nFrames / numberOfBeats
+	}
+
+	table.Lock()
+	table.slices = slices
+	table.Unlock()
+
+	return len(slices), nil
+}
+
+// gets the start frames of the slices of the sound file in a slot
+func (e *Engine) Slices(slot int) ([]int, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	table, exists := e.tables[slot]
+	if !exists {
+		return nil, errorTableDoesNotExist
+	}
+	return append([]int(nil), table.slices...), nil
+}
+
+// export each slice of the sound file in a slot into its own slot (starting
+// from firstSlot, so slice n is loaded into firstSlot + n), returning the
+// number of slices exported
+func (e *Engine) ExportSlices(slot, firstSlot int) (int, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	t, err := e.inMemoryTable(slot)
+	if err != nil {
+		return 0, err
+	}
+	slices := t.slices
+	for n := range slices {
+		start, end, _ := t.slice(n)
+		samples := make([]float64, (end-start+1)*t.channels)
+		copy(samples, t.samples[start*t.channels:(end+1)*t.channels])
+		e.tables[firstSlot+n] = &table{
+			name:       fmt.Sprintf("%s-slice-%d", t.name, n),
+			channels:   t.channels,
+			sampleRate: t.sampleRate,
+			samples:    samples,
+			nFrames:    end - start + 1,
+		}
+		delete(e.originalTables, firstSlot+n)
+	}
+	return len(slices), nil
+}
+
+// create/prepare a playback event of 1 slice of the sound file in a slot
+// (see Prepare() for the other arguments)
+func (e *Engine) PrepareSlice(slot, slice int, delayInSeconds, durationInSeconds float64) (*playbackEvent, error) {
+	p, err := e.Prepare(slot, delayInSeconds, durationInSeconds)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.SelectSlice(slice); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// set start/end to slice n of the table (see SliceOnsets(), SliceBeats())
+// and reset playback position to it
+func (tp *tablePlayer) SelectSlice(n int) error {
+	start, end, err := tp.table.slice(n)
+	if err != nil {
+		return err
+	}
+	tp.start = start
+	tp.end = end
+	tp.updateStreamCues()
+	tp.Trigger()
+	return nil
+}
@@ -0,0 +1,377 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// spectrum analysis
+//
+// A spectrum analyzer taps the master output (or a playback event) and
+// measures its (averaged) magnitude spectrum, for visualizers or checking
+// filter settings by eye.
+//
+// The audio thread hands the (mono mixed) audio to a lock-free ring, which a
+// goroutine drains, windowing and transforming (FFT) every hop.  The window
+// size (a power of 2) sets the frequency resolution, and the overlap (0 to
+// <1) how often it's transformed.  The power of each bin is averaged (over a
+// time constant) so the spectrum doesn't flicker.
+//
+// The spectrum has windowSize/2 + 1 bins (from 0hz to nyquist) in db, where a
+// full scale sine is 0db (in its bin) and silence is GainNegativeInfinity.
+//
+// ex:
+//  analyzer, _ := e.StartSpectrumAnalysis(2048, 0.5, stereophonic.HannWindow)
+//  ...
+//  for bin, db := range analyzer.Spectrum() {
+//      fmt.Println(analyzer.BinFrequency(bin), db)
+//  }
+//  ...
+//  e.StopSpectrumAnalysis()
+
+type WindowFunction int
+
+const (
+	HannWindow WindowFunction = iota
+	HammingWindow
+	BlackmanHarrisWindow
+	RectangularWindow
+)
+
+const (
+	// the smallest (and largest) window size
+	minimumSpectrumWindowSize int = 16
+	maximumSpectrumWindowSize int = 65536
+	// how much audio the ring between the audio thread and the analysis
+	// goroutine holds
+	spectrumBufferInSeconds float64 = 1.0
+	// how many samples the audio thread gathers before writing them (all at
+	// once) to the ring
+	spectrumBlockSize int = 128
+	// how often the analysis goroutine drains the ring
+	spectrumPollInterval = 10 * time.Millisecond
+	// the default time constant of the averaging
+	defaultSpectrumAveragingInSeconds float64 = 0.2
+)
+
+var (
+	errorInvalidWindowSize     error = fmt.Errorf("window size must be a power of 2 (from %d to %d)", minimumSpectrumWindowSize, maximumSpectrumWindowSize)
+	errorInvalidOverlap        error = fmt.Errorf("overlap must be from 0 to (less than) 1")
+	errorAlreadyAnalyzing      error = fmt.Errorf("spectrum analysis is already running")
+	errorNotAnalyzing          error = fmt.Errorf("spectrum analysis isn't running")
+	errorInvalidWindowFunction error = fmt.Errorf("invalid window function")
+)
+
+type SpectrumAnalyzer struct {
+	// guards the averaged power (shared by the analysis goroutine and
+	// readers)
+	sync.Mutex
+	sampleRate          float64
+	windowSize, hopSize int
+	window              []float64
+	// the gain which makes a full scale sine 0db
+	normalization float64
+	// the averaging (per hop) of the power of each bin
+	averaging float64
+	// (mono) audio from the audio thread, gathered into blocks
+	ring       *sampleRing
+	block      []float32
+	blockIndex int
+	// scratch buffer for draining the ring
+	buffer []float32
+	// the most recent windowSize samples (circular) and how many samples
+	// until the next hop
+	history         []float64
+	historyIndex    int
+	samplesUntilFFT int
+	// fft scratch
+	re, im []float64
+	// the averaged power of each bin
+	power []float64
+	// closed to stop the analysis goroutine
+	stop      chan struct{}
+	finished  chan struct{}
+	isStarted int32
+	isClosed  int32
+}
+
+// whether n is a power of 2
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// the coefficients of a window function
+func newWindow(windowFunction WindowFunction, size int) ([]float64, error) {
+	window := make([]float64, size)
+	for n := range window {
+		x := 2.0 * math.Pi * float64(n) / float64(size)
+		switch windowFunction {
+		case HannWindow:
+			window[n] = 0.5 - 0.5*math.Cos(x)
+		case HammingWindow:
+			window[n] = 0.54 - 0.46*math.Cos(x)
+		case BlackmanHarrisWindow:
+			window[n] = 0.35875 - 0.48829*math.Cos(x) + 0.14128*math.Cos(2.0*x) - 0.01168*math.Cos(3.0*x)
+		case RectangularWindow:
+			window[n] = 1.0
+		default:
+			return nil, errorInvalidWindowFunction
+		}
+	}
+	return window, nil
+}
+
+// create a spectrum analyzer (its analysis goroutine starts with start())
+func newSpectrumAnalyzer(sampleRate float64, windowSize int, overlap float64, windowFunction WindowFunction) (*SpectrumAnalyzer, error) {
+	if !isPowerOfTwo(windowSize) || windowSize < minimumSpectrumWindowSize || windowSize > maximumSpectrumWindowSize {
+		return nil, errorInvalidWindowSize
+	}
+	if overlap < 0.0 || overlap >= 1.0 {
+		return nil, errorInvalidOverlap
+	}
+	window, err := newWindow(windowFunction, windowSize)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := 0.0
+	for _, w := range window {
+		sum += w
+	}
+	hopSize := int(math.Max(1.0, math.Round(float64(windowSize)*(1.0-overlap))))
+	capacity := int(math.Max(spectrumBufferInSeconds*sampleRate, float64(4*windowSize)))
+
+	a := &SpectrumAnalyzer{
+		sampleRate:      sampleRate,
+		windowSize:      windowSize,
+		hopSize:         hopSize,
+		window:          window,
+		normalization:   2.0 / sum,
+		ring:            newSampleRing(capacity),
+		block:           make([]float32, spectrumBlockSize),
+		buffer:          make([]float32, capacity),
+		history:         make([]float64, windowSize),
+		samplesUntilFFT: windowSize,
+		re:              make([]float64, windowSize),
+		im:              make([]float64, windowSize),
+		power:           make([]float64, windowSize/2+1),
+		stop:            make(chan struct{}),
+		finished:        make(chan struct{}),
+	}
+	a.SetAveraging(defaultSpectrumAveragingInSeconds)
+	return a, nil
+}
+
+// start the analysis goroutine (only once, and not once it's closed)
+func (a *SpectrumAnalyzer) start() {
+	if atomic.CompareAndSwapInt32(&a.isStarted, 0, 1) {
+		go a.run()
+	}
+}
+
+// hand a frame to the analyzer
+// called from the audio thread, so it never blocks (should the ring be full,
+// the audio is dropped from the analysis)
+func (a *SpectrumAnalyzer) tick(left, right float64) {
+	a.block[a.blockIndex] = float32(0.5 * (left + right))
+	if a.blockIndex++; a.blockIndex == len(a.block) {
+		a.ring.write(a.block)
+		a.blockIndex = 0
+	}
+}
+
+// the analysis goroutine
+func (a *SpectrumAnalyzer) run() {
+	defer close(a.finished)
+
+	ticker := time.NewTicker(spectrumPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.drain()
+		}
+	}
+}
+
+// analyze everything in the ring
+func (a *SpectrumAnalyzer) drain() {
+	for {
+		n := a.ring.readInto(a.buffer)
+		if n == 0 {
+			return
+		}
+		for _, sample := range a.buffer[:n] {
+			a.history[a.historyIndex] = float64(sample)
+			if a.historyIndex++; a.historyIndex == a.windowSize {
+				a.historyIndex = 0
+			}
+			if a.samplesUntilFFT--; a.samplesUntilFFT == 0 {
+				a.analyze()
+				a.samplesUntilFFT = a.hopSize
+			}
+		}
+	}
+}
+
+// transform the (windowed) history, and average in its power
+func (a *SpectrumAnalyzer) analyze() {
+	for n := range a.re {
+		// oldest to newest
+		a.re[n] = a.history[(a.historyIndex+n)%a.windowSize] * a.window[n]
+		a.im[n] = 0.0
+	}
+	fft(a.re, a.im)
+
+	a.Lock()
+	defer a.Unlock()
+	for k := range a.power {
+		magnitude := math.Hypot(a.re[k], a.im[k]) * a.normalization
+		// dc and nyquist have no mirror image (so they'd read twice as loud)
+		if k == 0 || k == a.windowSize/2 {
+			magnitude *= 0.5
+		}
+		a.power[k] = a.power[k]*a.averaging + magnitude*magnitude*(1.0-a.averaging)
+	}
+}
+
+// get the (averaged) magnitude spectrum, in db, from 0hz (bin 0) to nyquist
+// (bin windowSize/2)
+func (a *SpectrumAnalyzer) Spectrum() []float64 {
+	a.Lock()
+	defer a.Unlock()
+	spectrum := make([]float64, len(a.power))
+	for k, power := range a.power {
+		spectrum[k] = amplitudeToDecibels(math.Sqrt(power))
+	}
+	return spectrum
+}
+
+// get the frequency (in hz) of the center of a bin
+func (a *SpectrumAnalyzer) BinFrequency(bin int) float64 {
+	a.Lock()
+	defer a.Unlock()
+	return float64(bin) * a.sampleRate / float64(a.windowSize)
+}
+
+// get how many bins the spectrum has
+func (a *SpectrumAnalyzer) Bins() int {
+	return len(a.power)
+}
+
+// set the time constant (in seconds) the spectrum is averaged over, 0 turns
+// off averaging
+func (a *SpectrumAnalyzer) SetAveraging(timeInSeconds float64) {
+	a.Lock()
+	defer a.Unlock()
+	a.averaging = 0.0
+	if timeInSeconds > 0.0 {
+		hopInSeconds := float64(a.hopSize) / a.sampleRate
+		a.averaging = math.Exp(-hopInSeconds / timeInSeconds)
+	}
+}
+
+// change the sample rate (keeping the averaging time the same)
+func (a *SpectrumAnalyzer) setSampleRate(sampleRate float64) {
+	a.Lock()
+	defer a.Unlock()
+	a.averaging = math.Pow(a.averaging, a.sampleRate/sampleRate)
+	a.sampleRate = sampleRate
+}
+
+// stop the analysis goroutine (the last spectrum can still be read)
+func (a *SpectrumAnalyzer) close() {
+	if atomic.CompareAndSwapInt32(&a.isClosed, 0, 1) {
+		close(a.stop)
+		// (had it never started, it's finished already)
+		if atomic.CompareAndSwapInt32(&a.isStarted, 0, 1) {
+			close(a.finished)
+		}
+	}
+}
+
+// start analyzing the spectrum of the master output, windowSize must be a
+// power of 2, and overlap from 0 to (less than) 1
+func (e *Engine) StartSpectrumAnalysis(windowSize int, overlap float64, windowFunction WindowFunction) (*SpectrumAnalyzer, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if !e.started {
+		return nil, errorEngineNotStarted
+	}
+	if a, _ := e.spectrumAnalyzer.Load().(*SpectrumAnalyzer); a != nil {
+		return nil, errorAlreadyAnalyzing
+	}
+
+	a, err := newSpectrumAnalyzer(e.streamSampleRate, windowSize, overlap, windowFunction)
+	if err != nil {
+		return nil, err
+	}
+	// from here on out, the stream callback analyzes
+	a.start()
+	e.spectrumAnalyzer.Store(a)
+
+	return a, nil
+}
+
+// stop analyzing the spectrum of the master output
+func (e *Engine) StopSpectrumAnalysis() error {
+	e.Lock()
+	defer e.Unlock()
+	return e.stopSpectrumAnalysis()
+}
+
+// (the unlocked implementation of StopSpectrumAnalysis)
+func (e *Engine) stopSpectrumAnalysis() error {
+	a, _ := e.spectrumAnalyzer.Load().(*SpectrumAnalyzer)
+	if a == nil {
+		return errorNotAnalyzing
+	}
+	// detach it from the stream callback
+	e.spectrumAnalyzer.Store((*SpectrumAnalyzer)(nil))
+	a.close()
+	<-a.finished
+	return nil
+}
+
+// the spectrum analyzer of the playback event (nil unless it's analyzing)
+func (tp *tablePlayer) currentSpectrumAnalyzer() *SpectrumAnalyzer {
+	a, _ := tp.spectrumAnalyzer.Load().(*SpectrumAnalyzer)
+	return a
+}
+
+// start analyzing the spectrum of the playback event (see
+// Engine.StartSpectrumAnalysis()).  The analysis goroutine only starts once
+// the event is played, and it stops by itself when the event is done (or is
+// dropped, or the engine is closed).
+func (tp *tablePlayer) StartSpectrumAnalysis(windowSize int, overlap float64, windowFunction WindowFunction) (*SpectrumAnalyzer, error) {
+	if tp.currentSpectrumAnalyzer() != nil {
+		return nil, errorAlreadyAnalyzing
+	}
+	a, err := newSpectrumAnalyzer(tp.sampleRate, windowSize, overlap, windowFunction)
+	if err != nil {
+		return nil, err
+	}
+	if tp.isPlayed {
+		a.start()
+	}
+	tp.spectrumAnalyzer.Store(a)
+	return a, nil
+}
+
+// stop analyzing the spectrum of the playback event
+func (tp *tablePlayer) StopSpectrumAnalysis() error {
+	a := tp.currentSpectrumAnalyzer()
+	if a == nil {
+		return errorNotAnalyzing
+	}
+	tp.spectrumAnalyzer.Store((*SpectrumAnalyzer)(nil))
+	a.close()
+	return nil
+}
@@ -0,0 +1,219 @@
+package stereophonic
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// engine performance statistics
+//
+// The stream callback times itself every buffer, and keeps (lock-free)
+// statistics of how long it takes, how much of the buffer's period that is
+// (the load, where >= 1 means it can't keep up and the output stutters),
+// how many events are playing/queued/dropped, and how often portaudio
+// reported an xrun (an underflow/overflow of the input/output).
+//
+// When the load goes over a threshold (0.8 by default), or an xrun is
+// reported, an OverloadWarning is sent (without ever blocking the audio
+// thread, if the channel is full it's dropped) on a buffered channel.
+//
+// ex:
+//  go func() {
+//      for w := range e.OverloadWarnings() {
+//          log.Println("overload", w.Load, e.Stats())
+//      }
+//  }()
+
+const (
+	// the default load which warns of an overload
+	defaultOverloadThreshold float64 = 0.8
+	// how many overload warnings are buffered before dropping
+	overloadWarningBufferSize int = 16
+)
+
+// a snapshot of the engine's performance (since it started, or ResetStats())
+type EngineStats struct {
+	// how many times the stream callback was called
+	Callbacks int64
+	// how long the stream callback took
+	MinCallbackDuration, AverageCallbackDuration, MaxCallbackDuration time.Duration
+	// how much of the buffer's period the stream callback took (the last
+	// and the worst)
+	Load, MaxLoad float64
+	// how many playback events are playing, waiting to be played, and
+	// were dropped (as the queue was full)
+	Voices, QueuedEvents int
+	DroppedEvents        int64
+	// how many xruns portaudio reported
+	InputUnderflows, InputOverflows   int64
+	OutputUnderflows, OutputOverflows int64
+}
+
+// a warning (sent from the audio thread) that the stream callback overloaded
+type OverloadWarning struct {
+	// the load of the callback which overloaded
+	Load float64
+	// the xruns portaudio reported to it
+	InputUnderflow, InputOverflow   bool
+	OutputUnderflow, OutputOverflow bool
+	// the stream time it happened
+	Time time.Duration
+}
+
+type engineStats struct {
+	// everything here is accessed atomically (and int64s/uint64s are
+	// first for 64 bit alignment).  Durations are in nanoseconds, and
+	// float64s are stored as their bits.
+	callbacks, totalDuration, minDuration, maxDuration int64
+	droppedEvents                                      int64
+	inputUnderflows, inputOverflows                    int64
+	outputUnderflows, outputOverflows                  int64
+	load, maxLoad, sampleRate, overloadThreshold       uint64
+	voices                                             int32
+	// flag (set by ResetStats()) for the audio thread to clear the statistics
+	shouldReset int32
+	// overload warnings (never closed)
+	overloadWarnings chan OverloadWarning
+}
+
+func newEngineStats() *engineStats {
+	s := &engineStats{
+		overloadWarnings: make(chan OverloadWarning, overloadWarningBufferSize),
+	}
+	atomic.StoreUint64(&s.overloadThreshold, math.Float64bits(defaultOverloadThreshold))
+	return s
+}
+
+// set the sample rate (which determines the period of a buffer)
+func (s *engineStats) setSampleRate(sampleRate float64) {
+	atomic.StoreUint64(&s.sampleRate, math.Float64bits(sampleRate))
+}
+
+// count a flag (if it's set), returning whether it was
+func countFlag(counter *int64, flags, flag portaudio.StreamCallbackFlags) bool {
+	if flags&flag == 0 {
+		return false
+	}
+	atomic.AddInt64(counter, 1)
+	return true
+}
+
+// update the statistics with a callback (called from the audio thread)
+func (s *engineStats) update(duration time.Duration, frames, voices int, timeInfo portaudio.StreamCallbackTimeInfo, flags portaudio.StreamCallbackFlags) {
+	if atomic.LoadInt32(&s.shouldReset) != 0 {
+		s.clear()
+		atomic.StoreInt32(&s.shouldReset, 0)
+	}
+
+	// timing (the audio thread is the only writer, so min/max needn't
+	// compare and swap)
+	d := int64(duration)
+	callbacks := atomic.AddInt64(&s.callbacks, 1)
+	atomic.AddInt64(&s.totalDuration, d)
+	if callbacks == 1 || d < atomic.LoadInt64(&s.minDuration) {
+		atomic.StoreInt64(&s.minDuration, d)
+	}
+	if d > atomic.LoadInt64(&s.maxDuration) {
+		atomic.StoreInt64(&s.maxDuration, d)
+	}
+
+	// load
+	load := 0.0
+	if sampleRate := math.Float64frombits(atomic.LoadUint64(&s.sampleRate)); sampleRate > 0.0 && frames > 0 {
+		load = duration.Seconds() * sampleRate / float64(frames)
+	}
+	atomic.StoreUint64(&s.load, math.Float64bits(load))
+	if load > math.Float64frombits(atomic.LoadUint64(&s.maxLoad)) {
+		atomic.StoreUint64(&s.maxLoad, math.Float64bits(load))
+	}
+
+	atomic.StoreInt32(&s.voices, int32(voices))
+
+	// xruns
+	inputUnderflow := countFlag(&s.inputUnderflows, flags, portaudio.InputUnderflow)
+	inputOverflow := countFlag(&s.inputOverflows, flags, portaudio.InputOverflow)
+	outputUnderflow := countFlag(&s.outputUnderflows, flags, portaudio.OutputUnderflow)
+	outputOverflow := countFlag(&s.outputOverflows, flags, portaudio.OutputOverflow)
+
+	// warn of an overload
+	isOverloaded := load >= math.Float64frombits(atomic.LoadUint64(&s.overloadThreshold))
+	if isOverloaded || inputUnderflow || inputOverflow || outputUnderflow || outputOverflow {
+		select {
+		case s.overloadWarnings <- OverloadWarning{
+			Load:            load,
+			InputUnderflow:  inputUnderflow,
+			InputOverflow:   inputOverflow,
+			OutputUnderflow: outputUnderflow,
+			OutputOverflow:  outputOverflow,
+			Time:            timeInfo.CurrentTime,
+		}:
+		default:
+			// dropped
+		}
+	}
+}
+
+// zero the statistics
+func (s *engineStats) clear() {
+	for _, counter := range []*int64{
+		&s.callbacks, &s.totalDuration, &s.minDuration, &s.maxDuration,
+		&s.droppedEvents,
+		&s.inputUnderflows, &s.inputOverflows,
+		&s.outputUnderflows, &s.outputOverflows,
+	} {
+		atomic.StoreInt64(counter, 0)
+	}
+	atomic.StoreUint64(&s.load, 0)
+	atomic.StoreUint64(&s.maxLoad, 0)
+}
+
+// get a snapshot of the engine's performance, this is safe to call from
+// anywhere (it doesn't lock the engine)
+func (e *Engine) Stats() EngineStats {
+	s := e.stats
+	stats := EngineStats{
+		Callbacks:           atomic.LoadInt64(&s.callbacks),
+		MinCallbackDuration: time.Duration(atomic.LoadInt64(&s.minDuration)),
+		MaxCallbackDuration: time.Duration(atomic.LoadInt64(&s.maxDuration)),
+		Load:                math.Float64frombits(atomic.LoadUint64(&s.load)),
+		MaxLoad:             math.Float64frombits(atomic.LoadUint64(&s.maxLoad)),
+		Voices:              int(atomic.LoadInt32(&s.voices)),
+		QueuedEvents:        len(e.newPlaybackEvents),
+		DroppedEvents:       atomic.LoadInt64(&s.droppedEvents),
+		InputUnderflows:     atomic.LoadInt64(&s.inputUnderflows),
+		InputOverflows:      atomic.LoadInt64(&s.inputOverflows),
+		OutputUnderflows:    atomic.LoadInt64(&s.outputUnderflows),
+		OutputOverflows:     atomic.LoadInt64(&s.outputOverflows),
+	}
+	if stats.Callbacks > 0 {
+		stats.AverageCallbackDuration = time.Duration(atomic.LoadInt64(&s.totalDuration) / stats.Callbacks)
+	}
+	return stats
+}
+
+// zero the statistics (if the engine is started, the audio thread does so on
+// its next callback)
+func (e *Engine) ResetStats() {
+	e.Lock()
+	defer e.Unlock()
+	if !e.started {
+		e.stats.clear()
+		return
+	}
+	atomic.StoreInt32(&e.stats.shouldReset, 1)
+}
+
+// get the channel of overload warnings (every call returns the same channel)
+// NB. the channel is never closed
+func (e *Engine) OverloadWarnings() <-chan OverloadWarning {
+	return e.stats.overloadWarnings
+}
+
+// set the load (from 0 to 1, the fraction of the buffer's period the stream
+// callback takes) which warns of an overload
+func (e *Engine) SetOverloadThreshold(load float64) {
+	atomic.StoreUint64(&e.stats.overloadThreshold, math.Float64bits(math.Max(load, 0.0)))
+}
@@ -0,0 +1,208 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// time stretching (independent of pitch)
+//
+// SetSpeed() and SetNote() change pitch and duration together, as they only
+// change how fast the phase moves through the table.  When time stretching,
+// the tablePlayer's phase (the playhead) moves through the table at
+// speed / stretch ratio, while the audio is made of short overlapping (hann
+// windowed) grains read from the playhead at speed * pitch shift.
+//
+// This is WSOLA (waveform similarity overlap-add): each new grain doesn't
+// start exactly at the playhead, but at the nearby position (within a small
+// tolerance) which best resembles how the previous grain would have
+// continued.  This keeps the overlapping grains in phase, avoiding most of
+// the "phasey" warble of plain granular stretching.
+//
+// more info here:
+// https://www.surina.net/article/time-and-pitch-scaling.html
+
+const (
+	// duration of each grain (the hop between grains is half this)
+	stretchGrainInSeconds float64 = 0.05
+	// how far from the playhead a grain may start (to match the previous)
+	stretchToleranceInSeconds float64 = 0.006
+	// how many frames are compared when matching grains, and the stride
+	// (in frames) between them
+	stretchCorrelationFrames int = 128
+	stretchCorrelationStride int = 4
+)
+
+type stretchGrain struct {
+	// fractional frame index into the table
+	position float64
+	// how many frames this grain has played (it's done at grainSize)
+	age int
+}
+
+type timeStretcher struct {
+	// how many times longer playback takes (> 0)
+	ratio float64
+	// pitch shift (as a playback rate multiplier)
+	pitch float64
+	// grain duration, the hop between grains, and the tolerance, in frames
+	grainSize, hop, tolerance int
+	// hann window (2 of them overlapping by half sum to 1)
+	window []float64
+	// 2 grains are enough to overlap by half
+	grains [2]stretchGrain
+	// which grain is spawned next
+	next int
+	// frames until the next grain is spawned
+	countdown int
+	// the continuation of the previous grain (which a new grain should match)
+	reference []float64
+}
+
+func newTimeStretcher(sampleRate float64) *timeStretcher {
+	hop := int(stretchGrainInSeconds * sampleRate / 2)
+	if hop < 1 {
+		hop = 1
+	}
+	ts := &timeStretcher{
+		ratio:     1.0,
+		pitch:     1.0,
+		grainSize: 2 * hop,
+		hop:       hop,
+		tolerance: int(stretchToleranceInSeconds * sampleRate),
+		window:    make([]float64, 2*hop),
+		reference: make([]float64, stretchCorrelationFrames),
+	}
+	for n := range ts.window {
+		ts.window[n] = 0.5 - 0.5*math.Cos(2.0*math.Pi*float64(n)/float64(ts.grainSize))
+	}
+	// start with both grains finished
+	for g := range ts.grains {
+		ts.grains[g].age = ts.grainSize
+	}
+	return ts
+}
+
+// change the sample rate (starting over with grains of the same duration)
+func (ts *timeStretcher) setSampleRate(sampleRate float64) {
+	stretcher := newTimeStretcher(sampleRate)
+	stretcher.ratio, stretcher.pitch = ts.ratio, ts.pitch
+	*ts = *stretcher
+}
+
+// start over (the next tick spawns a grain at the playhead)
+func (ts *timeStretcher) reset() {
+	for g := range ts.grains {
+		ts.grains[g].age = ts.grainSize
+	}
+	ts.countdown = 0
+}
+
+// compute a (stereo) frame of stretched audio
+func (ts *timeStretcher) tick(tp *tablePlayer) (float64, float64) {
+	var left, right float64
+
+	// how fast grains read the table
+	rate := tp.phaseIncrement * ts.pitch
+
+	if ts.countdown == 0 {
+		ts.spawn(tp, rate)
+		ts.countdown = ts.hop
+	}
+	ts.countdown--
+
+	// overlap-add the grains
+	for g := range ts.grains {
+		grain := &ts.grains[g]
+		if grain.age >= ts.grainSize {
+			continue
+		}
+		l, r := tp.readFrameAt(grain.position)
+		w := ts.window[grain.age]
+		left += l * w
+		right += r * w
+		grain.position += rate
+		grain.age++
+	}
+
+	return left, right
+}
+
+// spawn a new grain near the playhead
+func (ts *timeStretcher) spawn(tp *tablePlayer, rate float64) {
+	position := tp.phase
+	previous := &ts.grains[1-ts.next]
+	// match the previous grain (if it's still playing).  NB. streaming
+	// tables are skipped, as searching would have their disk reader
+	// chase positions all over the place
+	if tp.stream == nil && previous.age < ts.grainSize {
+		position += ts.bestOffset(tp, position, previous.position, rate)
+	}
+	grain := &ts.grains[ts.next]
+	grain.position = position
+	grain.age = 0
+	ts.next = 1 - ts.next
+}
+
+// find the offset (within the tolerance) from position, where the table best
+// resembles the audio at target (by normalized cross correlation)
+func (ts *timeStretcher) bestOffset(tp *tablePlayer, position, target, rate float64) float64 {
+	stride := float64(stretchCorrelationStride) * rate
+
+	for k := range ts.reference {
+		ts.reference[k] = tp.readMonoFrame(int(target + float64(k)*stride))
+	}
+
+	best, bestScore := 0, math.Inf(-1)
+	for d := -ts.tolerance; d <= ts.tolerance; d++ {
+		var correlation, energy float64
+		start := position + float64(d)
+		for k, reference := range ts.reference {
+			x := tp.readMonoFrame(int(start + float64(k)*stride))
+			correlation += x * reference
+			energy += x * x
+		}
+		if energy == 0 {
+			continue
+		}
+		if score := correlation / math.Sqrt(energy); score > bestScore {
+			best, bestScore = d, score
+		}
+	}
+	return float64(best)
+}
+
+// the time stretcher of the table player, created the first time it's needed
+// (most events never stretch, so they don't pay for its window)
+func (tp *tablePlayer) timeStretcher() *timeStretcher {
+	if tp.stretcher == nil {
+		tp.stretcher = newTimeStretcher(tp.sampleRate)
+	}
+	return tp.stretcher
+}
+
+// turn time stretching on/off
+// when on, the stretch ratio and pitch shift take effect (see below)
+func (tp *tablePlayer) SetTimeStretch(isStretching bool) {
+	if isStretching && !tp.isStretching {
+		tp.timeStretcher().reset()
+	}
+	tp.isStretching = isStretching
+}
+
+// set how many times longer playback takes (only accepts arguments > 0)
+// without changing its pitch (time stretching must be on)
+// ex:
+//  tp.SetStretchRatio(2.0) // => half as fast
+//  tp.SetStretchRatio(0.5) // => twice as fast
+func (tp *tablePlayer) SetStretchRatio(ratio float64) {
+	if ratio <= 0 {
+		return
+	}
+	tp.timeStretcher().ratio = ratio
+}
+
+// set the pitch shift in (possibly fractional) semitones, without changing
+// the duration of playback (time stretching must be on)
+func (tp *tablePlayer) SetPitchShift(semitones float64) {
+	tp.timeStretcher().pitch = math.Pow(2, semitones/12.0)
+}
@@ -0,0 +1,65 @@
+package stereophonic
+
+import (
+	"math"
+)
+
+// a zero delay feedback (trapezoidal integrated) state variable filter
+//
+// Unlike the simple filter, it's stable at any cutoff and resonance, and its
+// response doesn't depend on the sample rate.  All of its responses (lowpass,
+// highpass, bandpass, notch, peak, allpass) are computed at once from its 2
+// states.
+//
+// adapted from andrew simper's (cytomic) technical paper:
+// https://cytomic.com/files/dsp/SvfLinearTrapOptimised2.pdf
+
+type svf struct {
+	// damping (1/q), which resonance lowers towards 0
+	k float64
+	// coefficients
+	a1, a2, a3 float64
+	// the states of the 2 integrators
+	ic1eq, ic2eq float64
+}
+
+// set the coefficients from the cutoff frequency (relative to the sample
+// rate) and resonance (0 to 1)
+func (s *svf) setCoefficients(frequency, resonance float64) {
+	g := math.Tan(math.Pi * frequency)
+	s.k = 2.0 - 2.0*resonance
+	s.a1 = 1.0 / (1.0 + g*(g+s.k))
+	s.a2 = g * s.a1
+	s.a3 = g * s.a2
+}
+
+// filter the input, returning the response of the filter mode
+func (s *svf) tick(input float64, filterMode FilterMode) float64 {
+	v3 := input - s.ic2eq
+	v1 := s.a1*s.ic1eq + s.a2*v3
+	v2 := s.ic2eq + s.a2*s.ic1eq + s.a3*v3
+	s.ic1eq = 2.0*v1 - s.ic1eq
+	s.ic2eq = 2.0*v2 - s.ic2eq
+
+	var (
+		low  = v2
+		band = v1
+		high = input - s.k*band - low
+	)
+	switch filterMode {
+	case LPFilter:
+		return low
+	case HPFilter:
+		return high
+	case BPFilter:
+		return band
+	case NotchFilter:
+		return low + high
+	case PeakFilter:
+		return low - high
+	case AllpassFilter:
+		return low + high - s.k*band
+	default:
+		return input
+	}
+}
@@ -25,6 +25,15 @@ type table struct {
 	samples    []float64 // interleaved
 	nFrames    int
 	sync.Mutex // lock when mutating the samples
+	// streaming tables only keep the head of their sound file (the first
+	// nPreloadFrames frames) in samples, the remainder is read from disk
+	// during playback (see diskstream.go)
+	streaming      bool
+	nPreloadFrames int
+	// loops, markers, root note (see metadata.go)
+	metadata SampleMetadata
+	// the start frames of each slice (see slicing.go)
+	slices []int
 }
 
 // force immutability by disallowing setters
@@ -45,6 +54,10 @@ func (b *table) NFrames() int {
 	return b.nFrames
 }
 
+func (b *table) Metadata() SampleMetadata {
+	return b.metadata
+}
+
 // create a new table from a sound file
 // (most common use case for table)
 func newTable(soundFileName string) (*table, error) {
@@ -143,6 +156,7 @@ func (b *table) loadFile(soundFileName string) error {
 	b.sampleRate = float64(sf.Format.Samplerate)
 	b.samples = samples
 	b.nFrames = int(framesRead)
+	b.metadata = readSampleMetadata(soundFileName)
 
 	// return without error
 	return nil
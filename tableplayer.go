@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sync/atomic"
 )
 
 // tablePlayer (obviously enough) keeps track of playback
@@ -23,11 +24,15 @@ import (
 // struct represents *1* instance of playback for *1* table.
 //
 // Things which are modifiable in realtime:
-// the speed (pitch), amplitude, dc-offset, start/end points,
-// as well as loop-start/loop-end points, forwards and reverse playback
+// the speed (pitch), amplitude, dc-offset, pan, start/end points,
+// as well as loop-start/loop-end points and loop modes, forwards and reverse playback,
+// and time stretching (duration independent of pitch)
 //
 
 type tablePlayer struct {
+	// how many frames have elapsed since playback began (accessed
+	// atomically, and kept first for 64 bit alignment)
+	elapsedFrames int64
 	// sample rate of the original sound file
 	sampleRate float64
 	// used to determine mismatch between playback sample rate and original sound file sample rate
@@ -40,6 +45,14 @@ type tablePlayer struct {
 	// NB. we don't need to store a "balance" variable as the setter
 	// calculates the left/right channel multipliers
 	balanceMultiplierLeft, balanceMultiplierRight float64
+	// panning (see pan.go), the pan position, stereo width, and pan law
+	// determine a (target) matrix which the frame is multiplied by.  The
+	// matrix moves towards its target by the smoothing factor each tick.
+	isPanning                  bool
+	pan, stereoWidth           float64
+	panLaw                     PanLaw
+	panMatrix, targetPanMatrix [4]float64
+	panSmoothingFactor         float64
 	// amplitude envelope (when release occurs on this adsr, the doneAction
 	// of the playback event is run (removing it from the active playback
 	// events of the engine)
@@ -57,6 +70,9 @@ type tablePlayer struct {
 	filterADSREnvelope *adsrEnvelope
 	// the frame data we read from (the table)
 	table *table
+	// reads the frames of a streaming table which aren't preloaded in
+	// memory (nil for regular tables)
+	stream *diskReader
 	// current frame index in the table
 	// which ranges from 0 to table.nFrames - 1
 	phase float64
@@ -66,6 +82,9 @@ type tablePlayer struct {
 	//   phaseIncrement > 0 --> forwards playback
 	//   phaseIncrement < 0 --> reverse playback
 	phaseIncrement float64
+	// playback rate multiplier which tunes the table (see
+	// ApplySampleMetadata()), it's applied on top of the speed
+	tuning float64
 	// this is a destination rate of playback (phase increment)
 	// we want to acheive.  It's necessary for simulating pitch slides
 	targetPhaseIncrement float64
@@ -77,13 +96,31 @@ type tablePlayer struct {
 	// true  --> looping
 	// false --> one shot
 	isLooping bool
+	// how playback behaves at the loop points (see loop.go)
+	loopMode LoopMode
+	// whether the loop only loops until released, and whether it has been
+	isSustainLoop, isSustainReleased bool
+	// whether a one-shot-then-loop has finished its first pass (and entered
+	// the loop)
+	isInLoop bool
 	// offset (and looping offset) frame indices
 	start     int
 	end       int
 	loopStart int
 	loopEnd   int
+	// how many frames (before the loop wraps) the end of the loop is
+	// crossfaded with the audio before the loop start (0 => no crossfade)
+	loopCrossfade int
 	// whether we are in reverse playback
 	isReversed bool
+	// time stretching (see stretch.go), the stretcher is nil until time
+	// stretching is first configured
+	isStretching bool
+	stretcher    *timeStretcher
+	// granular playback (see granular.go), the granulator is nil unless
+	// the event is granular
+	isGranular bool
+	granulator *granulator
 	// whether we are finished playback (that is reached the start or end)
 	// in a particular direction (forwards or reverse).  NB. this cannot be
 	// true if we're looping
@@ -96,6 +133,21 @@ type tablePlayer struct {
 	// theoretically be runtime available as a setter (altering kMaxTicks).
 	kRate                   float64
 	kCurrentTick, kMaxTicks int
+	// lifecycle notifications (see notifications.go), nil unless subscribed
+	notifications chan PlaybackNotification
+	// whether the first frame has been ticked
+	hasStarted bool
+	// the *meter (see meter.go), a nil *meter unless metering.  It's an
+	// atomic.Value as it's set while the audio thread meters with it
+	meter atomic.Value
+	// the *SpectrumAnalyzer (see spectrum.go), a nil one unless analyzing.
+	// It's an atomic.Value too, for the same reason
+	spectrumAnalyzer atomic.Value
+	// whether it's been handed to the engine to play (its spectrum
+	// analysis starts then)
+	isPlayed bool
+	// which pair of output channels it plays on (see outputs.go)
+	outputPair int
 }
 
 func newTablePlayer(t *table, sampleRate float64) (*tablePlayer, error) {
@@ -116,8 +168,8 @@ func newTablePlayer(t *table, sampleRate float64) (*tablePlayer, error) {
 	}
 
 	// create filters
-	filterLeft := newFilter()
-	filterRight := newFilter()
+	filterLeft := newFilter(sampleRate)
+	filterRight := newFilter(sampleRate)
 
 	// create amplitude ADSR envelope (with default values)
 	defaultAmplitudeADSRAttack := 0.0
@@ -165,6 +217,11 @@ func newTablePlayer(t *table, sampleRate float64) (*tablePlayer, error) {
 		dcOffset:               0.0,
 		balanceMultiplierLeft:  1.0,
 		balanceMultiplierRight: 1.0,
+		isPanning:              false,
+		pan:                    0.0,
+		stereoWidth:            1.0,
+		panLaw:                 PanLawMinus3dB,
+		panSmoothingFactor:     smoothingFactor(defaultPanSmoothingInSeconds, sampleRate),
 		filterLeft:             filterLeft,
 		filterRight:            filterRight,
 		amplitudeADSREnvelope:  amplitudeADSREnvelope,
@@ -173,16 +230,24 @@ func newTablePlayer(t *table, sampleRate float64) (*tablePlayer, error) {
 		filterEnvelopeDepth:    defaultFilterEnvelopeDepth,
 		table:                  t,
 		phase:                  0.0,
+		tuning:                 1.0,
 		phaseIncrement:         srFactor, /* speed == 1.0 at *player's* sampleRate */
 		targetPhaseIncrement:   srFactor, /* where we want to eventually arrive    */
 		slideFactor:            0.0,      /* how fast we arrive there              */
 		isLooping:              false,
+		loopMode:               ForwardLoop,
+		isSustainLoop:          false,
+		isSustainReleased:      false,
+		isInLoop:               false,
 		isReversed:             false,
 		isFinished:             false,
 		start:                  0,
 		end:                    t.nFrames - 1,
 		loopStart:              0,
 		loopEnd:                t.nFrames - 1,
+		loopCrossfade:          0,
+		isStretching:           false,
+		isGranular:             false,
 		kRate:                  kRate,
 		kCurrentTick:           0,
 		kMaxTicks:              int(sampleRate/kRate + 1),
@@ -190,6 +255,12 @@ func newTablePlayer(t *table, sampleRate float64) (*tablePlayer, error) {
 	// correct possible sample rate mismatch between the table and the table player
 	tp.SetSpeed(1.0)
 
+	// streaming tables read (most of) their frames from disk
+	if t.streaming {
+		tp.stream = newDiskReader(t)
+		tp.updateStreamCues()
+	}
+
 	return tp, nil
 }
 
@@ -205,6 +276,13 @@ func (tp *tablePlayer) tick() (float64, float64) {
 		right float64
 	)
 
+	// notify the first frame
+	if !tp.hasStarted {
+		tp.hasStarted = true
+		tp.notify(StartedNotification)
+	}
+	atomic.AddInt64(&tp.elapsedFrames, 1)
+
 	// check if we are finished progression (forwards or backwards)
 	// if looping is on, this will be false (necessarily)
 	if tp.isFinished {
@@ -231,19 +309,17 @@ func (tp *tablePlayer) tick() (float64, float64) {
 	i := int(tp.phase)
 
 	// read the samples in this frame
-	switch tp.table.channels {
-	// mono
-	case 1:
-		left = tp.table.samples[i]
-		right = left
-	// stereo
-	case 2:
-		left = tp.table.samples[2*i]
-		right = tp.table.samples[2*i+1]
-	//
+	// (or the grains of them when granular or time stretching)
+	switch {
+	case tp.isGranular && tp.granulator != nil:
+		left, right = tp.granulator.tick(tp)
+	case tp.isStretching && tp.stretcher != nil:
+		left, right = tp.stretcher.tick(tp)
 	default:
-		left = 0.0
-		right = 0.0
+		left, right = tp.readFrame(i)
+		if tp.isLoopActive() && tp.loopCrossfade > 0 && tp.loopWraps() {
+			left, right = tp.crossfadeLoop(left, right)
+		}
 	}
 
 	// filter
@@ -280,8 +356,22 @@ func (tp *tablePlayer) tick() (float64, float64) {
 	left *= a * tp.balanceMultiplierLeft
 	right *= a * tp.balanceMultiplierRight
 
+	// pan (and widen)
+	if tp.isPanning {
+		left, right = tp.panFrame(left, right)
+	}
+
 	// update phase
-	tp.phase += tp.phaseIncrement
+	// (time stretching moves through the table slower/faster than it reads,
+	// and granular playback doesn't move through it at all, its grains do)
+	switch {
+	case tp.isGranular:
+		break
+	case tp.isStretching && tp.stretcher != nil:
+		tp.phase += tp.phaseIncrement / tp.stretcher.ratio
+	default:
+		tp.phase += tp.phaseIncrement
+	}
 
 	// update phase increment
 	// explanation:
@@ -321,28 +411,15 @@ func (tp *tablePlayer) tick() (float64, float64) {
 	next := int(tp.phase)
 
 	var (
-		start            = tp.start
-		end              = tp.end
-		loopStart        = tp.loopStart
-		loopEnd          = tp.loopEnd
-		forwardsPlayback = tp.phaseIncrement >= 0.0
+		start = tp.start
+		end   = tp.end
 	)
 
-	if tp.isLooping {
-
-		// out of bounds detection
-		switch {
-
-		// forwards looping
-		case forwardsPlayback && loopEnd < next:
-			// reset phase to loop start
-			tp.phase = float64(loopStart)
+	if tp.isLoopActive() {
 
-		// reverse looping
-		case !forwardsPlayback && next < loopStart:
-			// reset phase to loop end
-			tp.phase = float64(loopEnd)
-		}
+		// wrap (or bounce) at the loop points, depending on the loop
+		// mode (see loop.go)
+		tp.wrapLoop(next)
 	} else {
 
 		// out of bounds detection
@@ -354,6 +431,7 @@ func (tp *tablePlayer) tick() (float64, float64) {
 			tp.phase = float64(end)
 			// flag that we are finished playback
 			tp.isFinished = true
+			tp.notify(ReachedEndNotification)
 
 		// reverse (no looping)
 		case next < start:
@@ -361,6 +439,7 @@ func (tp *tablePlayer) tick() (float64, float64) {
 			tp.phase = float64(start)
 			// flag that we are finished playback
 			tp.isFinished = true
+			tp.notify(ReachedEndNotification)
 		}
 	}
 
@@ -368,6 +447,106 @@ func (tp *tablePlayer) tick() (float64, float64) {
 	return left, right
 }
 
+// read the samples of frame i in the table
+// mono frames are duplicated into left and right
+func (tp *tablePlayer) readFrame(i int) (float64, float64) {
+	// frames after the preloaded head of a streaming table come from disk
+	if tp.stream != nil && i >= tp.table.nPreloadFrames {
+		return tp.stream.frame(i)
+	}
+	switch tp.table.channels {
+	// mono
+	case 1:
+		return tp.table.samples[i], tp.table.samples[i]
+	// stereo
+	case 2:
+		return tp.table.samples[2*i], tp.table.samples[2*i+1]
+	//
+	default:
+		return 0.0, 0.0
+	}
+}
+
+// read the samples at a (fractional) position in the table, interpolating
+// linearly between frames.  Positions outside the table read as silence.
+func (tp *tablePlayer) readFrameAt(position float64) (float64, float64) {
+	i := int(math.Floor(position))
+	if i < 0 || i >= tp.table.nFrames {
+		return 0.0, 0.0
+	}
+	left, right := tp.readFrame(i)
+	if i+1 == tp.table.nFrames {
+		return left, right
+	}
+	nextLeft, nextRight := tp.readFrame(i + 1)
+	frac := position - float64(i)
+	return left + frac*(nextLeft-left), right + frac*(nextRight-right)
+}
+
+// read frame i (summed to mono), frames outside the table read as silence
+func (tp *tablePlayer) readMonoFrame(i int) float64 {
+	if i < 0 || i >= tp.table.nFrames {
+		return 0.0
+	}
+	left, right := tp.readFrame(i)
+	return left + right
+}
+
+// crossfade the current frame (left, right) as the loop approaches its wrap
+//
+// forwards, the last frames before the loop end fade into the frames which
+// lead up to the loop start (so when the phase wraps to the loop start, it's
+// already what we're hearing).  Reverse is the mirror image, the first frames
+// after the loop start fade into the frames which follow the loop end.
+// Nothing in the table is modified.
+func (tp *tablePlayer) crossfadeLoop(left, right float64) (float64, float64) {
+
+	var (
+		loopLength = float64(tp.loopEnd - tp.loopStart + 1)
+		// the crossfade can't be longer than the loop, or than the
+		// audio available on the other side of the loop
+		crossfade float64
+		// how far through the crossfade we are (0 to 1)
+		x float64
+		// where the other frame (across the loop) is
+		other float64
+	)
+
+	if tp.phaseIncrement >= 0.0 {
+		crossfade = math.Min(float64(tp.loopCrossfade), math.Min(loopLength, float64(tp.loopStart)))
+		fadeStart := float64(tp.loopEnd+1) - crossfade
+		if crossfade < 1.0 || tp.phase < fadeStart {
+			return left, right
+		}
+		x = (tp.phase - fadeStart) / crossfade
+		other = tp.phase - loopLength
+	} else {
+		crossfade = math.Min(float64(tp.loopCrossfade), math.Min(loopLength, float64(tp.table.nFrames-1-tp.loopEnd)))
+		fadeEnd := float64(tp.loopStart) + crossfade
+		if crossfade < 1.0 || tp.phase >= fadeEnd {
+			return left, right
+		}
+		x = (fadeEnd - tp.phase) / crossfade
+		other = tp.phase + loopLength
+	}
+
+	// equal power crossfade
+	x = math.Max(0.0, math.Min(x, 1.0))
+	fadeOut := math.Cos(x * math.Pi / 2.0)
+	fadeIn := math.Sin(x * math.Pi / 2.0)
+	otherLeft, otherRight := tp.readFrame(int(other))
+	return left*fadeOut + otherLeft*fadeIn, right*fadeOut + otherRight*fadeIn
+}
+
+// inform the disk reader (of a streaming table) where playback may jump to
+func (tp *tablePlayer) updateStreamCues() {
+	if tp.stream == nil {
+		return
+	}
+	tp.stream.setCues(tp.start, tp.end, tp.loopStart, tp.loopEnd)
+	tp.stream.setReversed(tp.phaseIncrement < 0.0)
+}
+
 // set looping mode, true => looping on, false => looping off
 func (tp *tablePlayer) SetLooping(loopingOn bool) {
 	if loopingOn {
@@ -377,6 +556,15 @@ func (tp *tablePlayer) SetLooping(loopingOn bool) {
 
 }
 
+// set how long the loop crossfades (in seconds) as it wraps around, which
+// removes the click of loop points that don't match up.  The audio just
+// before the loop start (or after the loop end, in reverse) is what's faded
+// in, so the crossfade is limited by how much of that there is.
+// 0 turns off the crossfade
+func (tp *tablePlayer) SetLoopCrossfade(crossfadeInSeconds float64) {
+	tp.loopCrossfade = int(math.Max(0.0, crossfadeInSeconds*tp.table.sampleRate))
+}
+
 // set start/end
 // where start/end are in the range [0, 1)
 // and start < end
@@ -398,6 +586,7 @@ func (tp *tablePlayer) SetSlice(start, end float64) {
 			// save the new start/end indices
 			tp.start = s
 			tp.end = e
+			tp.updateStreamCues()
 		}
 	}
 }
@@ -428,6 +617,7 @@ func (tp *tablePlayer) SetLoopSlice(loopStart, loopEnd float64) {
 			// save the new start/end indices
 			tp.loopStart = s
 			tp.loopEnd = e
+			tp.updateStreamCues()
 		}
 	}
 }
@@ -454,21 +644,52 @@ func (tp *tablePlayer) Trigger() {
 		// begin playback at "start" position
 		tp.phase = float64(tp.start)
 	}
+	// head back in the direction of playback (after bouncing off a loop
+	// point) and start the loop afresh
+	if tp.isBounced() {
+		tp.bounce()
+	}
+	tp.isInLoop = false
 	tp.isFinished = false
+	// grains from the old position shouldn't linger
+	if tp.stretcher != nil {
+		tp.stretcher.reset()
+	}
 }
 
 // (re)sets the envelopes to their attack stage, regardless of current stage
+// (how they get there depends on the retrigger mode, see SetRetriggerMode())
 func (tp *tablePlayer) Attack() {
+	// a sustain loop loops again
+	tp.isSustainReleased = false
 	tp.amplitudeADSREnvelope.attack()
 	tp.filterADSREnvelope.attack()
 }
 
+// set how Attack() retriggers the envelopes while they're sounding:
+// RetriggerReset:            fade (quickly) to silence, then attack
+// RetriggerFromCurrentLevel: (the default) attack from the current level
+// RetriggerLegato:           keep going (don't retrigger)
+func (tp *tablePlayer) SetRetriggerMode(retriggerMode RetriggerMode) {
+	tp.amplitudeADSREnvelope.setRetriggerMode(retriggerMode)
+	tp.filterADSREnvelope.setRetriggerMode(retriggerMode)
+}
+
 // (re)sets the envelopes to their release stage, regardless of current stage
 // NB, this will (possibly) remove the tableplayer from the active players if
 // it fully releases, as the amplitude adsr (specifically) has a doneAction callback
 // which removes the playback event from the active events in the engine
 // (assuming it fully releases, that is enters an off stage)
+// A sustain loop stops looping, playing the tail of the slice instead
 func (tp *tablePlayer) Release() {
+	tp.notify(ReleaseBeganNotification)
+	if tp.isSustainLoop && !tp.isSustainReleased {
+		tp.isSustainReleased = true
+		// the tail is played in the direction of playback
+		if tp.isBounced() {
+			tp.bounce()
+		}
+	}
 	tp.amplitudeADSREnvelope.release()
 	tp.filterADSREnvelope.release()
 }
@@ -480,13 +701,15 @@ func (tp *tablePlayer) SetDCOffset(dc float64) {
 
 // specify the gain of the tablePlayer using decibels (0dBFS)
 // ex:
-//  tp.SetGain(6.0)                               // =>  6db increase in volume
-//  tp.SetGain(-3.0)                              // =>  3db decrease in volume
-//  tp.SetGain(0.0)                               // =>  0db (no change in volume)
-//  tp.SetGain(stereophonic.GainNegativeInfinity) // => -Inf db decrease in volume (amplitude == 0)
+//
+//	tp.SetGain(6.0)                               // =>  6db increase in volume
+//	tp.SetGain(-3.0)                              // =>  3db decrease in volume
+//	tp.SetGain(0.0)                               // =>  0db (no change in volume)
+//	tp.SetGain(stereophonic.GainNegativeInfinity) // => -Inf db decrease in volume (amplitude == 0)
 //
 // awesome brief discussion here:
-//   https://sound.stackexchange.com/a/25533
+//
+//	https://sound.stackexchange.com/a/25533
 func (tp *tablePlayer) SetGain(db float64) {
 	tp.amplitude = decibelsToAmplitude(db)
 }
@@ -504,8 +727,8 @@ func (tp *tablePlayer) SetSpeed(speed float64, slideTime ...float64) {
 	currentSpeed := tp.phaseIncrement
 
 	// calculate the target speed
-	// (correcting for SR mismatch with the srFactor)
-	targetSpeed := speed * tp.srFactor
+	// (correcting for SR mismatch with the srFactor, and tuning)
+	targetSpeed := speed * tp.srFactor * tp.tuning
 
 	// handle if we're in reverse playback
 	if currentSpeed < 0 {
@@ -543,8 +766,11 @@ func (tp *tablePlayer) SetSpeed(speed float64, slideTime ...float64) {
 }
 
 // like SetSpeed, but integer note values which represent chromatic pitch offset
+// (the filter cutoff follows the note, if key tracking is on)
 func (tp *tablePlayer) SetNote(n int, slideTime ...float64) {
 	tp.SetSpeed(math.Pow(2, float64(n)/12.0), slideTime...)
+	tp.filterLeft.setNote(float64(n))
+	tp.filterRight.setNote(float64(n))
 }
 
 // turn on reverse playback(if it's not already on)
@@ -554,23 +780,26 @@ func (tp *tablePlayer) SetNote(n int, slideTime ...float64) {
 // end (upon table player creation, its default phase is set at the start
 // of the table).
 func (tp *tablePlayer) SetReverse(isReversed bool) {
-	// save it
-	tp.isReversed = isReversed
 	// if forwards playback and isReversed == true, set reverse playback
 	// or
 	// if reverse playback and isReversed == false, set foward playback
-	if (tp.phaseIncrement > 0 && isReversed == true) ||
-		(tp.phaseIncrement < 0 && isReversed == false) {
+	// NB. this compares against the saved direction (rather than the sign
+	// of the phase increment) as playback may have bounced off a loop point
+	if tp.isReversed != isReversed {
 		// invert all the phase increment variables
 		tp.phaseIncrement *= -1.0
 		tp.targetPhaseIncrement *= -1.0
 	}
+	// save it
+	tp.isReversed = isReversed
+	tp.updateStreamCues()
 }
 
 // set the balance of the signal
-// -1: left (right fully muted)
-//  0: center (nothing altered)
-//  1: right (left fully muted)
+//
+//	-1: left (right fully muted)
+//	 0: center (nothing altered)
+//	 1: right (left fully muted)
 func (tp *tablePlayer) SetBalance(balance float64) {
 	// make sure balance is between -1 and 1 (inclusive)
 	if balance < -1.0 || 1.0 < balance {
@@ -595,6 +824,18 @@ func (tp *tablePlayer) SetFilterMode(filterMode FilterMode) {
 	tp.filterLeft.setMode(filterMode)
 	tp.filterRight.setMode(filterMode)
 }
+
+// set the filter model (SimpleFilter, SVFFilter, LadderFilter), the cutoff
+// stays at the same frequency
+func (tp *tablePlayer) SetFilterModel(filterModel FilterModel) {
+	tp.filterLeft.setModel(filterModel)
+	tp.filterRight.setModel(filterModel)
+	// the cutoff (0 to 1) of the same frequency differs between models
+	tp.filterCutoff = tp.filterLeft.cutoff
+}
+
+// set the filter cutoff (0 to 1), see filter.go for what frequency that is
+// for each filter model
 func (tp *tablePlayer) SetFilterCutoff(cutoff float64) {
 	tp.filterLeft.setCutoff(cutoff)
 	tp.filterRight.setCutoff(cutoff)
@@ -606,11 +847,29 @@ func (tp *tablePlayer) SetFilterCutoff(cutoff float64) {
 	// (the left filter was arbitrarily chosen here, it doesn't matter)
 	tp.filterCutoff = tp.filterLeft.cutoff
 }
+
+// set the vowel (0 => a, 0.25 => e, 0.5 => i, 0.75 => o, 1 => u) of the
+// FormantFilter mode, which is its cutoff (so the filter envelope morphs it)
+func (tp *tablePlayer) SetFilterVowel(vowel float64) {
+	tp.SetFilterCutoff(vowel)
+}
+
+// set the filter cutoff in hz (independent of the sample rate)
+func (tp *tablePlayer) SetFilterCutoffInHz(hz float64) {
+	tp.SetFilterCutoff(tp.filterLeft.cutoffFromHz(hz))
+}
 func (tp *tablePlayer) SetFilterResonance(resonance float64) {
 	tp.filterLeft.setResonance(resonance)
 	tp.filterRight.setResonance(resonance)
 }
 
+// set how much (0 to 1) the filter cutoff follows the note (see SetNote()),
+// at 1 the cutoff moves an octave with each octave played (relative to note 0)
+func (tp *tablePlayer) SetFilterKeyTracking(keyTracking float64) {
+	tp.filterLeft.setKeyTracking(keyTracking)
+	tp.filterRight.setKeyTracking(keyTracking)
+}
+
 // setters filter cutoff envelope
 
 // continuously updating the filter coefficients is expensive, hence there's an
@@ -624,7 +883,7 @@ func (tp *tablePlayer) SetFilterEnvelopeDepth(filterEnvelopeDepth float64) {
 	tp.filterEnvelopeDepth = filterEnvelopeDepth
 }
 
-//adsr times
+// adsr times
 func (tp *tablePlayer) SetFilterAttack(attackTimeInSeconds float64) {
 	tp.filterADSREnvelope.setAttack(attackTimeInSeconds)
 }
@@ -637,6 +896,24 @@ func (tp *tablePlayer) SetFilterSustain(sustainLevel float64) {
 func (tp *tablePlayer) SetFilterRelease(releaseTimeInSeconds float64) {
 	tp.filterADSREnvelope.setRelease(releaseTimeInSeconds)
 }
+func (tp *tablePlayer) SetFilterDelay(delayTimeInSeconds float64) {
+	tp.filterADSREnvelope.setDelay(delayTimeInSeconds)
+}
+func (tp *tablePlayer) SetFilterHold(holdTimeInSeconds float64) {
+	tp.filterADSREnvelope.setHold(holdTimeInSeconds)
+}
+
+// adsr curves (see adsr.go), an optional tension (-1 to 1) is given for a
+// TensionCurve
+func (tp *tablePlayer) SetFilterAttackCurve(curve EnvelopeCurve, tension ...float64) {
+	tp.filterADSREnvelope.setCurve(adsrAttackStage, curve, optionalTension(tension))
+}
+func (tp *tablePlayer) SetFilterDecayCurve(curve EnvelopeCurve, tension ...float64) {
+	tp.filterADSREnvelope.setCurve(adsrDecayStage, curve, optionalTension(tension))
+}
+func (tp *tablePlayer) SetFilterReleaseCurve(curve EnvelopeCurve, tension ...float64) {
+	tp.filterADSREnvelope.setCurve(adsrReleaseStage, curve, optionalTension(tension))
+}
 
 // (amplitude) ADSR setters
 // can't use struct embedding here, as I might have multiple envelopes in the
@@ -653,3 +930,29 @@ func (tp *tablePlayer) SetAmplitudeSustain(sustainLevel float64) {
 func (tp *tablePlayer) SetAmplitudeRelease(releaseTimeInSeconds float64) {
 	tp.amplitudeADSREnvelope.setRelease(releaseTimeInSeconds)
 }
+func (tp *tablePlayer) SetAmplitudeDelay(delayTimeInSeconds float64) {
+	tp.amplitudeADSREnvelope.setDelay(delayTimeInSeconds)
+}
+func (tp *tablePlayer) SetAmplitudeHold(holdTimeInSeconds float64) {
+	tp.amplitudeADSREnvelope.setHold(holdTimeInSeconds)
+}
+
+// (amplitude) adsr curves (see adsr.go), an optional tension (-1 to 1) is
+// given for a TensionCurve
+func (tp *tablePlayer) SetAmplitudeAttackCurve(curve EnvelopeCurve, tension ...float64) {
+	tp.amplitudeADSREnvelope.setCurve(adsrAttackStage, curve, optionalTension(tension))
+}
+func (tp *tablePlayer) SetAmplitudeDecayCurve(curve EnvelopeCurve, tension ...float64) {
+	tp.amplitudeADSREnvelope.setCurve(adsrDecayStage, curve, optionalTension(tension))
+}
+func (tp *tablePlayer) SetAmplitudeReleaseCurve(curve EnvelopeCurve, tension ...float64) {
+	tp.amplitudeADSREnvelope.setCurve(adsrReleaseStage, curve, optionalTension(tension))
+}
+
+// the (optional) tension argument of the curve setters (0 if not given)
+func optionalTension(tension []float64) float64 {
+	if tension != nil {
+		return tension[0]
+	}
+	return 0.0
+}
@@ -0,0 +1,59 @@
+package stereophonic
+
+import (
+	"fmt"
+	"math"
+)
+
+// the tempo of the engine
+//
+// The engine has a tempo (in beats per minute) and a number of beats per bar,
+// which the looper quantizes its loops to (see looper.go).  By default it's
+// 120bpm in 4/4.
+//
+// ex:
+//  e.SetTempo(92.5)
+//  e.SetTempo(140, 3) // <--- 3 beats per bar
+
+const (
+	defaultTempo       float64 = 120.0
+	defaultBeatsPerBar int     = 4
+)
+
+var (
+	errorInvalidTempo       error = fmt.Errorf("invalid tempo (it must be greater than 0bpm)")
+	errorInvalidBeatsPerBar error = fmt.Errorf("invalid beats per bar (there must be at least 1)")
+)
+
+// set the tempo (in beats per minute), and optionally how many beats there
+// are per bar.  A loop that's already recorded keeps its length, the tempo
+// applies to the next recording.
+func (e *Engine) SetTempo(bpm float64, beatsPerBar ...int) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if bpm <= 0 || math.IsInf(bpm, 0) || math.IsNaN(bpm) {
+		return errorInvalidTempo
+	}
+	if beatsPerBar != nil {
+		if beatsPerBar[0] < 1 {
+			return errorInvalidBeatsPerBar
+		}
+		e.beatsPerBar = beatsPerBar[0]
+	}
+	e.tempo = bpm
+	return nil
+}
+
+// get the tempo (in beats per minute) and how many beats there are per bar
+func (e *Engine) Tempo() (float64, int) {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.tempo, e.beatsPerBar
+}
+
+// how many frames (at a sample rate) a bar of the tempo lasts (at least 1)
+func (e *Engine) barInFrames(sampleRate float64) int {
+	return int(math.Max(math.Round(float64(e.beatsPerBar)*60.0/e.tempo*sampleRate), 1.0))
+}